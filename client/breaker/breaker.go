@@ -0,0 +1,153 @@
+// Package breaker stops sending to a degraded franchise until it recovers.
+package breaker
+
+import (
+	"errors"
+	"megalink/gateway/client/metrics"
+	"sync"
+	"time"
+)
+
+// State of a circuit breaker.
+type State string
+
+const (
+	// StateClosed transactions flow to the franchise.
+	StateClosed State = "closed"
+	// StateOpen transactions are declined without reaching the franchise.
+	StateOpen State = "open"
+	// StateHalfOpen a few probe transactions tell if the franchise recovered.
+	StateHalfOpen State = "half-open"
+)
+
+// Outcome of a transaction allowed by a breaker.
+type Outcome int
+
+const (
+	// Success the franchise answered.
+	Success Outcome = iota
+	// Failure the franchise timed out or answered with a system error.
+	Failure
+	// Aborted the transaction never reached the franchise, it does not count.
+	Aborted
+)
+
+var (
+	// ErrOpen triggered when a transaction is not allowed because the franchise is degraded.
+	ErrOpen = errors.New("circuit breaker open")
+)
+
+type (
+	// IBreaker decides if a transaction may be sent to the franchise.
+	IBreaker interface {
+		// Allow tells if a transaction may be sent, done must be called with its outcome.
+		Allow() (done func(Outcome), err error)
+		// State gets the state of the breaker.
+		State() State
+	}
+
+	// Breaker implements IBreaker counting consecutive failures.
+	// It opens after FailureThreshold of them, lets HalfOpenProbes transactions through once OpenTimeout
+	// elapsed and closes as soon as one of them succeeds, a failed probe opens it again.
+	Breaker struct {
+		// Name of the franchise, used in metric names.
+		Name string
+		// FailureThreshold consecutive failures opening the breaker, never opened if 0.
+		FailureThreshold int
+		// OpenTimeout time declining transactions before probing the franchise.
+		OpenTimeout time.Duration
+		// HalfOpenProbes transactions sent at once while half open.
+		HalfOpenProbes int
+		Metrics        metrics.IMetrics
+		mtx            sync.Mutex
+		state          State
+		failures       int
+		openedAt       time.Time
+		probes         int
+	}
+)
+
+// NewBreaker provides a closed Breaker, at least one probe is sent while half open.
+func NewBreaker(
+	name string,
+	failureThreshold int,
+	openTimeout time.Duration,
+	halfOpenProbes int,
+	m metrics.IMetrics,
+) IBreaker {
+	if halfOpenProbes < 1 {
+		halfOpenProbes = 1
+	}
+	return &Breaker{
+		Name:             name,
+		FailureThreshold: failureThreshold,
+		OpenTimeout:      openTimeout,
+		HalfOpenProbes:   halfOpenProbes,
+		Metrics:          m,
+		state:            StateClosed,
+	}
+}
+
+// Allow tells if a transaction may be sent, done must be called with its outcome.
+func (b *Breaker) Allow() (func(Outcome), error) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if b.state == StateOpen {
+		if time.Since(b.openedAt) < b.OpenTimeout {
+			b.Metrics.Inc("breaker.rejected." + b.Name)
+			return nil, ErrOpen
+		}
+		b.state = StateHalfOpen
+		b.probes = 0
+	}
+
+	probe := false
+	if b.state == StateHalfOpen {
+		if b.probes >= b.HalfOpenProbes {
+			b.Metrics.Inc("breaker.rejected." + b.Name)
+			return nil, ErrOpen
+		}
+		b.probes++
+		probe = true
+	}
+
+	var once sync.Once
+	return func(outcome Outcome) {
+		once.Do(func() { b.done(probe, outcome) })
+	}, nil
+}
+
+// done records the outcome of an allowed transaction.
+func (b *Breaker) done(probe bool, outcome Outcome) {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+
+	if probe && b.probes > 0 {
+		b.probes--
+	}
+	// outcomes of transactions sent before the breaker opened do not count.
+	if outcome == Aborted || b.state == StateOpen {
+		return
+	}
+
+	if outcome == Success {
+		b.failures = 0
+		b.state = StateClosed
+		return
+	}
+
+	b.failures++
+	if b.state == StateHalfOpen || (b.FailureThreshold > 0 && b.failures >= b.FailureThreshold) {
+		b.state = StateOpen
+		b.openedAt = time.Now()
+		b.Metrics.Inc("breaker.opened." + b.Name)
+	}
+}
+
+// State gets the state of the breaker, open until the first probe once OpenTimeout elapsed.
+func (b *Breaker) State() State {
+	b.mtx.Lock()
+	defer b.mtx.Unlock()
+	return b.state
+}
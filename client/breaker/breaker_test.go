@@ -0,0 +1,130 @@
+package breaker
+
+import (
+	"errors"
+	"megalink/gateway/client/metrics"
+	"testing"
+	"time"
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	const openTimeout = 20 * time.Millisecond
+
+	type step struct {
+		// wait before asking for a transaction.
+		wait    time.Duration
+		outcome Outcome
+		// allowed tells if the transaction must be let through.
+		allowed bool
+		want    State
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "opens after threshold",
+			steps: []step{
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateOpen},
+				{allowed: false, want: StateOpen},
+			},
+		},
+		{
+			name: "success resets failures",
+			steps: []step{
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Success, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateClosed},
+			},
+		},
+		{
+			name: "aborted does not count",
+			steps: []step{
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Aborted, allowed: true, want: StateClosed},
+				{outcome: Aborted, allowed: true, want: StateClosed},
+			},
+		},
+		{
+			name: "half open probe closes",
+			steps: []step{
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateOpen},
+				{wait: openTimeout, outcome: Success, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateClosed},
+			},
+		},
+		{
+			name: "half open probe failure opens again",
+			steps: []step{
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateClosed},
+				{outcome: Failure, allowed: true, want: StateOpen},
+				{wait: openTimeout, outcome: Failure, allowed: true, want: StateOpen},
+				{allowed: false, want: StateOpen},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := NewBreaker("visa", 3, openTimeout, 1, metrics.NewMetrics())
+			for i, s := range tt.steps {
+				time.Sleep(s.wait)
+				done, err := b.Allow()
+				if s.allowed != (err == nil) {
+					t.Fatalf("step %d: Allow error = %v, want allowed %v", i, err, s.allowed)
+				}
+				if err == nil {
+					done(s.outcome)
+				} else if !errors.Is(err, ErrOpen) {
+					t.Fatalf("step %d: Allow error = %v, want ErrOpen", i, err)
+				}
+				if got := b.State(); got != s.want {
+					t.Fatalf("step %d: State = %s, want %s", i, got, s.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerHalfOpenLimitsProbes(t *testing.T) {
+	b := NewBreaker("visa", 1, time.Millisecond, 2, metrics.NewMetrics())
+	done, err := b.Allow()
+	if err != nil {
+		t.Fatalf("Allow: %v", err)
+	}
+	done(Failure)
+	time.Sleep(2 * time.Millisecond)
+
+	var probes []func(Outcome)
+	for i := 0; i < 2; i++ {
+		probe, err := b.Allow()
+		if err != nil {
+			t.Fatalf("probe %d: %v", i, err)
+		}
+		probes = append(probes, probe)
+	}
+	if b.State() != StateHalfOpen {
+		t.Fatalf("State = %s, want %s", b.State(), StateHalfOpen)
+	}
+	if _, err := b.Allow(); !errors.Is(err, ErrOpen) {
+		t.Fatalf("third probe error = %v, want ErrOpen", err)
+	}
+
+	probes[0](Success)
+	probes[0](Failure)
+	if b.State() != StateClosed {
+		t.Errorf("State = %s, want %s", b.State(), StateClosed)
+	}
+	probes[1](Aborted)
+	if b.State() != StateClosed {
+		t.Errorf("State after aborted probe = %s, want %s", b.State(), StateClosed)
+	}
+}
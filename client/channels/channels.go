@@ -1,84 +1,141 @@
+// Package channels keeps the requests waiting for a franchise response.
 package channels
 
-// CHMessageFields struct to send message.
-type CHMessageFields[T any] struct {
-	Resp T
-	ID   string
-}
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	// ErrDuplicate triggered when registering an id which is already waiting.
+	ErrDuplicate = errors.New("request already pending")
+	// ErrClosed triggered when the registry was cancelled as a whole.
+	ErrClosed = errors.New("pending registry closed")
+)
+
+type (
+	// Registry matches responses with the requests waiting for them.
+	// A pending request gets a single value, delivered on a buffered channel which is never closed,
+	// so resolving never blocks nor sends on a closed channel.
+	Registry[T any] struct {
+		mtx     sync.Mutex
+		pending map[string]*Pending[T]
+		closed  chan struct{}
+		once    sync.Once
+	}
+
+	// Pending is a request registered in a Registry.
+	Pending[T any] struct {
+		ID       string
+		registry *Registry[T]
+		ch       chan T
+	}
+)
 
-// ChannelStruct struct to use channels.
-type ChannelStruct[T any] struct {
-	MapChannels     map[string]chan CHMessageFields[T]
-	AddChannel      chan MapEntry[T]
-	RemoveChannel   chan string
-	ResponseChannel chan CHMessageFields[T]
+// NewRegistry provides an empty Registry.
+func NewRegistry[T any]() *Registry[T] {
+	return &Registry[T]{
+		pending: make(map[string]*Pending[T]),
+		closed:  make(chan struct{}),
+	}
 }
 
-// MapEntry struct to create channels.
-type MapEntry[T any] struct {
-	Id string
-	Ch chan CHMessageFields[T]
+// Register reserves id until it is resolved or cancelled.
+func (r *Registry[T]) Register(id string) (*Pending[T], error) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+
+	select {
+	case <-r.closed:
+		return nil, ErrClosed
+	default:
+	}
+	if _, ok := r.pending[id]; ok {
+		return nil, ErrDuplicate
+	}
+
+	p := &Pending[T]{ID: id, registry: r, ch: make(chan T, 1)}
+	r.pending[id] = p
+	return p, nil
 }
 
-// ProvideChannels func to initialize logic channels.
-func ProvideChannels[T any]() *ChannelStruct[T] {
-	channels := make(map[string]chan CHMessageFields[T])
-	addChannel := make(chan MapEntry[T])
-	removeChannel := make(chan string)
-	responseChannel := make(chan CHMessageFields[T])
-
-	go func() {
-		for {
-			select {
-			case ent := <-addChannel:
-				channels[ent.Id] = ent.Ch
-			case resp := <-responseChannel:
-				channel, ok := channels[resp.ID]
-				if ok {
-					channel <- resp
-				}
-			case id := <-removeChannel:
-				delete(channels, id)
-			}
-		}
-	}()
+// Resolve delivers value to the request waiting on id, it returns false if nobody waits for it.
+func (r *Registry[T]) Resolve(id string, value T) bool {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	p, ok := r.pending[id]
+	if !ok {
+		return false
+	}
+	delete(r.pending, id)
+
+	// the buffer is empty, only the first Resolve finds the id, so it never blocks.
+	// Delivering under the lock lets Abandon know if a value came in.
+	p.ch <- value
+	return true
+}
 
-	mc := &ChannelStruct[T]{
-		MapChannels:     channels,
-		AddChannel:      addChannel,
-		RemoveChannel:   removeChannel,
-		ResponseChannel: responseChannel,
+// Cancel stops waiting on p, a response arriving later is not delivered.
+func (r *Registry[T]) Cancel(p *Pending[T]) {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	// the id may be registered again by a newer request.
+	if r.pending[p.ID] == p {
+		delete(r.pending, p.ID)
 	}
-	return mc
 }
 
-// Set to update data messages from channels.
-func (m *ChannelStruct[T]) Set(msg CHMessageFields[T]) {
-	go func() {
-		m.ResponseChannel <- msg
-	}()
+// Len gets the number of pending requests.
+func (r *Registry[T]) Len() int {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	return len(r.pending)
 }
 
-// Delete to remove specific channel by ID.
-func (m *ChannelStruct[T]) Delete(id string) {
-	go func() {
-		m.RemoveChannel <- id
-	}()
+// CancelAll releases every waiting request with ErrClosed and rejects new registrations.
+func (r *Registry[T]) CancelAll() {
+	r.once.Do(func() {
+		r.mtx.Lock()
+		defer r.mtx.Unlock()
+		close(r.closed)
+		r.pending = make(map[string]*Pending[T])
+	})
 }
 
-// Init to create channel.
-func (m *ChannelStruct[T]) Init(id string) chan CHMessageFields[T] {
-	ch := make(chan CHMessageFields[T])
-	entry := MapEntry[T]{Id: id, Ch: ch}
-	m.AddChannel <- entry
-	return ch
+// Wait blocks until the response arrives, ctx is done or the registry is closed.
+func (p *Pending[T]) Wait(ctx context.Context) (T, error) {
+	var zero T
+	select {
+	case value := <-p.ch:
+		return value, nil
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case <-p.registry.closed:
+		// a response resolved right before closing still wins.
+		select {
+		case value := <-p.ch:
+			return value, nil
+		default:
+			return zero, ErrClosed
+		}
+	}
 }
 
-// CloseChannels to close all channels.
-func (m *ChannelStruct[T]) CloseChannels() {
-	go func() {
-		close(m.AddChannel)
-		close(m.ResponseChannel)
-		close(m.RemoveChannel)
-	}()
+// Cancel stops waiting, it is safe to call after the request was resolved.
+func (p *Pending[T]) Cancel() {
+	p.registry.Cancel(p)
+}
+
+// Abandon stops waiting and gets the value resolved meanwhile, if any.
+// Once it returns a later Resolve does not find the request.
+func (p *Pending[T]) Abandon() (T, bool) {
+	p.registry.Cancel(p)
+	select {
+	case value := <-p.ch:
+		return value, true
+	default:
+		var zero T
+		return zero, false
+	}
 }
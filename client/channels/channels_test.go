@@ -0,0 +1,137 @@
+package channels
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRegistryResolve(t *testing.T) {
+	r := NewRegistry[string]()
+	p, err := r.Register("000001")
+	if err != nil {
+		t.Fatalf("Register: %v", err)
+	}
+	if _, err := r.Register("000001"); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Register duplicate error = %v, want ErrDuplicate", err)
+	}
+
+	if !r.Resolve("000001", "response") {
+		t.Fatal("Resolve of a pending id returned false")
+	}
+	if r.Resolve("000001", "again") {
+		t.Error("second Resolve returned true, want false")
+	}
+	if r.Resolve("000002", "unknown") {
+		t.Error("Resolve of an unknown id returned true, want false")
+	}
+
+	got, err := p.Wait(context.Background())
+	if err != nil || got != "response" {
+		t.Errorf("Wait = %q, %v, want response", got, err)
+	}
+	if r.Len() != 0 {
+		t.Errorf("Len = %d, want 0", r.Len())
+	}
+}
+
+func TestRegistryWaitTimesOut(t *testing.T) {
+	r := NewRegistry[string]()
+	p, _ := r.Register("000001")
+	defer p.Cancel()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := p.Wait(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Wait error = %v, want DeadlineExceeded", err)
+	}
+}
+
+func TestRegistryCancel(t *testing.T) {
+	r := NewRegistry[string]()
+	old, _ := r.Register("000001")
+	old.Cancel()
+	if r.Resolve("000001", "late") {
+		t.Error("Resolve after Cancel returned true, want false")
+	}
+
+	// cancelling a stale request does not drop a newer one with the same id.
+	newer, _ := r.Register("000001")
+	old.Cancel()
+	if !r.Resolve("000001", "response") {
+		t.Fatal("Resolve of the newer request returned false")
+	}
+	if got, _ := newer.Wait(context.Background()); got != "response" {
+		t.Errorf("Wait = %q, want response", got)
+	}
+}
+
+func TestPendingAbandon(t *testing.T) {
+	r := NewRegistry[string]()
+
+	p, _ := r.Register("000001")
+	if _, ok := p.Abandon(); ok {
+		t.Error("Abandon without response returned ok")
+	}
+	if r.Resolve("000001", "late") {
+		t.Error("Resolve after Abandon returned true, want false")
+	}
+
+	p, _ = r.Register("000002")
+	r.Resolve("000002", "racing")
+	if got, ok := p.Abandon(); !ok || got != "racing" {
+		t.Errorf("Abandon = %q, %v, want racing", got, ok)
+	}
+}
+
+func TestRegistryCancelAll(t *testing.T) {
+	r := NewRegistry[string]()
+	waiting, _ := r.Register("000001")
+	resolved, _ := r.Register("000002")
+	r.Resolve("000002", "response")
+
+	r.CancelAll()
+	r.CancelAll()
+
+	if _, err := waiting.Wait(context.Background()); !errors.Is(err, ErrClosed) {
+		t.Errorf("Wait error = %v, want ErrClosed", err)
+	}
+	if got, err := resolved.Wait(context.Background()); err != nil || got != "response" {
+		t.Errorf("Wait of a resolved request = %q, %v, want response", got, err)
+	}
+	if _, err := r.Register("000003"); !errors.Is(err, ErrClosed) {
+		t.Errorf("Register after CancelAll error = %v, want ErrClosed", err)
+	}
+}
+
+func TestRegistryConcurrentResolve(t *testing.T) {
+	r := NewRegistry[int]()
+	p, _ := r.Register("000001")
+
+	var wg sync.WaitGroup
+	delivered := make(chan bool, 50)
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			delivered <- r.Resolve("000001", i)
+		}(i)
+	}
+	wg.Wait()
+	close(delivered)
+
+	count := 0
+	for ok := range delivered {
+		if ok {
+			count++
+		}
+	}
+	if count != 1 {
+		t.Errorf("%d Resolve calls delivered, want 1", count)
+	}
+	if _, err := p.Wait(context.Background()); err != nil {
+		t.Errorf("Wait: %v", err)
+	}
+}
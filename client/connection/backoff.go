@@ -0,0 +1,39 @@
+package connection
+
+import (
+	"math/rand"
+	"time"
+)
+
+// backoff gets exponentially growing waits between reconnect attempts, capped at max.
+// Half of every wait is random so links which dropped together do not redial together.
+type backoff struct {
+	initial time.Duration
+	max     time.Duration
+	current time.Duration
+}
+
+func newBackoff(initial, max time.Duration) *backoff {
+	if initial <= 0 {
+		initial = time.Second
+	}
+	if max < initial {
+		max = initial
+	}
+	return &backoff{initial: initial, max: max}
+}
+
+// Next gets the wait before the next attempt.
+func (b *backoff) Next() time.Duration {
+	switch {
+	case b.current == 0:
+		b.current = b.initial
+	case b.current*2 > b.max:
+		b.current = b.max
+	default:
+		b.current *= 2
+	}
+
+	half := b.current / 2
+	return half + time.Duration(rand.Int63n(int64(half)+1))
+}
@@ -0,0 +1,48 @@
+package connection
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBackoffGrowsUpToMax(t *testing.T) {
+	b := newBackoff(time.Second, 10*time.Second)
+	for i, current := range []time.Duration{1, 2, 4, 8, 10, 10} {
+		current *= time.Second
+		for j := 0; j < 20; j++ {
+			// the attempt is redone with the same wait bounds.
+			saved := b.current
+			wait := b.Next()
+			if wait < current/2 || wait > current {
+				t.Fatalf("wait #%d = %s, want between %s and %s", i, wait, current/2, current)
+			}
+			if j < 19 {
+				b.current = saved
+			}
+		}
+	}
+}
+
+func TestBackoffDefaults(t *testing.T) {
+	tests := []struct {
+		name     string
+		initial  time.Duration
+		max      time.Duration
+		attempts int
+		want     time.Duration
+	}{
+		{name: "no initial", initial: 0, max: 10 * time.Second, attempts: 1, want: time.Second},
+		{name: "max below initial", initial: 5 * time.Second, max: time.Second, attempts: 3, want: 5 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBackoff(tt.initial, tt.max)
+			for i := 0; i < tt.attempts; i++ {
+				if wait := b.Next(); wait > tt.want || wait < tt.want/2 {
+					t.Errorf("wait #%d = %s, want between %s and %s", i, wait, tt.want/2, tt.want)
+				}
+			}
+		})
+	}
+}
@@ -1,9 +1,11 @@
 package connection
 
 import (
+	"errors"
 	"fmt"
 	"megalink/gateway/client/types"
 	"net"
+	"sync"
 )
 
 var (
@@ -18,25 +20,40 @@ type (
 	// IConnFactory is a net.Conn provider.
 	IConnFactory interface {
 		GetConnection() (net.Conn, error)
+		// MarkFailed moves the next connection to the following address, after a heartbeat failure.
+		MarkFailed()
+		// OnPrimary tells if the last connection was made to the primary address.
+		OnPrimary() bool
+		// ProbePrimary dials the primary address and hangs up.
+		ProbePrimary() error
+		// UsePrimary makes the next connection go to the primary address.
+		UsePrimary()
 	}
 
 	// ConnFactory deals with connection details to provide net.Conn per environment.
+	// Addresses are tried in order from the active one, the first being the primary host.
 	ConnFactory struct {
 		Cfg *types.EnvVars
+		// Addresses of the franchise hosts, primary first then backups.
+		Addresses []string
+		mtx       sync.Mutex
+		active    int
 	}
 )
 
 // NewConnFactory initializes a new IConnFactory.
 func NewConnFactory(
 	envCfg *types.EnvVars,
+	addresses []string,
 ) IConnFactory {
 
 	return &ConnFactory{
-		Cfg: envCfg,
+		Cfg:       envCfg,
+		Addresses: addresses,
 	}
 }
 
-// GetConnection creates a new net.Conn.
+// GetConnection creates a new net.Conn, failing over to the next address when dialing fails.
 func (cf *ConnFactory) GetConnection() (net.Conn, error) {
 	net, err := cf.providePlainConnection()
 	if err != nil {
@@ -46,14 +63,66 @@ func (cf *ConnFactory) GetConnection() (net.Conn, error) {
 	return net, err
 }
 
+// MarkFailed moves the next connection to the following address.
+func (cf *ConnFactory) MarkFailed() {
+	cf.mtx.Lock()
+	defer cf.mtx.Unlock()
+	if len(cf.Addresses) > 0 {
+		cf.active = (cf.active + 1) % len(cf.Addresses)
+	}
+}
+
+// OnPrimary tells if the last connection was made to the primary address.
+func (cf *ConnFactory) OnPrimary() bool {
+	cf.mtx.Lock()
+	defer cf.mtx.Unlock()
+	return cf.active == 0
+}
+
+// ProbePrimary dials the primary address and hangs up.
+func (cf *ConnFactory) ProbePrimary() error {
+	if len(cf.Addresses) == 0 {
+		return errors.New("no franchise address")
+	}
+	conn, err := NetDialerFn("tcp", cf.Addresses[0])
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// UsePrimary makes the next connection go to the primary address.
+func (cf *ConnFactory) UsePrimary() {
+	cf.mtx.Lock()
+	defer cf.mtx.Unlock()
+	cf.active = 0
+}
+
 func (cf *ConnFactory) providePlainConnection() (net.Conn, error) {
-	return cf.provideInsecureConnection()
+	cf.mtx.Lock()
+	defer cf.mtx.Unlock()
+	if len(cf.Addresses) == 0 {
+		return nil, errors.New("no franchise address")
+	}
+
+	var errs []error
+	for i := 0; i < len(cf.Addresses); i++ {
+		index := (cf.active + i) % len(cf.Addresses)
+		conn, err := cf.provideInsecureConnection(cf.Addresses[index])
+		if err == nil {
+			if index != cf.active {
+				fmt.Printf("\nConnFactory | failed over to %s", cf.Addresses[index])
+			}
+			cf.active = index
+			return conn, nil
+		}
+		errs = append(errs, err)
+	}
+	return nil, errors.Join(errs...)
 }
 
 // ProvideConnection provides a simple TCP connection.
-func (cf *ConnFactory) provideInsecureConnection() (net.Conn, error) {
-	address := cf.Cfg.FranchiseConnectionAdress
-
+func (cf *ConnFactory) provideInsecureConnection(address string) (net.Conn, error) {
 	fmt.Printf("\nTrying to establish an insecure connection with %s \n", address)
 
 	return NetDialerFn("tcp", address)
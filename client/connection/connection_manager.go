@@ -3,15 +3,19 @@ package connection
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"megalink/gateway/client/heartbeat"
+	"megalink/gateway/client/metrics"
 	"megalink/gateway/client/sign"
 	"megalink/gateway/client/types"
+	"megalink/gateway/shared/framing"
 	"net"
 	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,6 +23,13 @@ const (
 	connManagerTag = "ConnManager | %s"
 )
 
+var (
+	// ErrNoConnection triggered when writing before a connection with franchise was set up.
+	ErrNoConnection = errors.New("no connection with franchise")
+	// ErrUnavailable triggered when a franchise has no link up to take a request.
+	ErrUnavailable = errors.New("franchise unavailable")
+)
+
 type (
 	// ScheduledTask represents a calendared task.
 	ScheduledTask func(writer io.ReadWriter)
@@ -29,37 +40,142 @@ type (
 	}
 )
 
-// ScheduleTask spawns a goroutine at a specified interval
-// returns ticker to caller.
-func (sc *Scheduler) ScheduleTask(fn ScheduledTask, interval time.Duration) *time.Ticker {
+// ScheduleTask spawns a goroutine running fn at a specified interval until ctx is done.
+func (sc *Scheduler) ScheduleTask(ctx context.Context, fn ScheduledTask, interval time.Duration) {
 	ticker := time.NewTicker(interval)
 
-	go func(ticker *time.Ticker) {
-		for range ticker.C {
-			fn(sc.Conn)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fn(sc.Conn)
+			}
 		}
-	}(ticker)
+	}()
+}
+
+type (
+	// frameWriter is the single outbound path to the franchise, it frames every message and serializes
+	// writes so concurrent transactions, sign on and heartbeats never interleave partial frames.
+	frameWriter struct {
+		mtx    sync.Mutex
+		framer framing.Framer
+	}
+
+	// generation is a single connection with the franchise, read by its own listener.
+	// Writes are framed by the link so they never interleave with the ones of other writers.
+	generation struct {
+		cm   *ConnManager
+		conn net.Conn
+	}
+)
+
+// Read reads from the connection.
+func (g *generation) Read(b []byte) (int, error) {
+	return g.conn.Read(b)
+}
+
+// Write frames b as a single message and writes it to the connection.
+func (g *generation) Write(b []byte) (int, error) {
+	return g.cm.writer.writeFrame(g.conn, b)
+}
 
-	return ticker
+// WorkingKey gets the MAC working key of the link.
+func (g *generation) WorkingKey() string {
+	return g.cm.WorkingKey()
+}
+
+// writeFrame frames b and writes the whole frame to conn while holding the write lock.
+func (fw *frameWriter) writeFrame(conn io.Writer, b []byte) (int, error) {
+	frame, err := fw.framer.Frame(b)
+	if err != nil {
+		return 0, err
+	}
+
+	fw.mtx.Lock()
+	defer fw.mtx.Unlock()
+	for written := 0; written < len(frame); {
+		n, err := conn.Write(frame[written:])
+		if err != nil {
+			return 0, err
+		}
+		written += n
+	}
+	return len(b), nil
 }
 
 type (
+	// ListenFunc reads the messages of conn until ctx is done or conn fails, see listener.Listener.
+	ListenFunc func(ctx context.Context, conn io.ReadWriter) error
+
 	// IConnManager deals with connection details with franchise.
 	IConnManager interface {
 		net.Conn
 		SetupConnection(context.Context) error
 		CloseConnection() error
 		TryReconnect()
+		// AddConnectedHook registers fn to run after every successful sign on.
+		AddConnectedHook(fn func(context.Context))
+		// Shutdown signs off and closes the connection for good.
+		Shutdown() error
+		// State gets the state of the link with the franchise.
+		State() State
+		// Subscribe registers fn to be called on every state transition, fn must not block.
+		Subscribe(fn func(Transition))
+	}
+
+	// IPoolMember is a single link of a Pool.
+	IPoolMember interface {
+		IConnManager
+		// Ready tells if the link may take new requests.
+		Ready() bool
+		// InFlight gets the number of requests waiting for a response on the link.
+		InFlight() int
+		// TrackInFlight counts a request on the link until done is called.
+		TrackInFlight() (done func())
+		// OnPrimary tells if the link is connected to the primary franchise host.
+		OnPrimary() bool
+		// ProbePrimary dials the primary franchise host and hangs up.
+		ProbePrimary() error
+		// Failback drains the link and moves it back to the primary franchise host.
+		Failback()
+		// WorkingKey gets the MAC working key exchanged on this link.
+		WorkingKey() string
 	}
 
 	// ConnManager implements IConnManager to deal with connection to franchise.
+	// Every connection gets its own listener from dialing until the link is disconnected, the heartbeat
+	// runs while the link is Ready, reads and writes are only allowed from SigningOn to Draining.
 	ConnManager struct {
 		SignService       sign.ISignService
 		HeartbeatService  heartbeat.IHeartbeatService
 		Connection        net.Conn
 		ConnectionMtx     *sync.RWMutex
 		ConnectionFactory IConnFactory
+		Framer            framing.Framer
 		EnvVars           *types.EnvVars
+		Metrics           metrics.IMetrics
+		Listen            ListenFunc
+		// Name of the franchise, used in metric names.
+		Name           string
+		writer         *frameWriter
+		connectedHooks []func(context.Context)
+		states         *stateMachine
+		// lifecycleMtx guards stopHeartbeat and stopListener, the connection lock is held by a blocked Read.
+		lifecycleMtx sync.Mutex
+		// stopHeartbeat cancels the heartbeat and the key changes of the current connection.
+		stopHeartbeat context.CancelFunc
+		// stopListener cancels the listener of the current connection.
+		stopListener context.CancelFunc
+		// done is closed on Shutdown to stop a reconnect waiting for its next attempt.
+		done     chan struct{}
+		doneOnce sync.Once
+		// reconnecting is set while a reconnect loop runs so only one runs at a time.
+		reconnecting int32
+		inFlight     int64
 	}
 )
 
@@ -68,46 +184,245 @@ func NewConnManager(
 	signService sign.ISignService,
 	heartbeatService heartbeat.IHeartbeatService,
 	connectionFactory IConnFactory,
+	framer framing.Framer,
 	envVars *types.EnvVars,
-) IConnManager {
-	return &ConnManager{
+	m metrics.IMetrics,
+	name string,
+	listen ListenFunc,
+) IPoolMember {
+	cm := &ConnManager{
 		SignService:       signService,
 		HeartbeatService:  heartbeatService,
 		Connection:        nil,
 		ConnectionFactory: connectionFactory,
 		ConnectionMtx:     &sync.RWMutex{},
+		Framer:            framer,
 		EnvVars:           envVars,
+		Metrics:           m,
+		Listen:            listen,
+		Name:              name,
+		writer:            &frameWriter{framer: framer},
+		states:            newStateMachine(name),
+		done:              make(chan struct{}),
 	}
+	cm.states.Subscribe(cm.onTransition)
+	return cm
 }
 
 // SetupConnection sets up a connection with the franchise.
 func (cm *ConnManager) SetupConnection(ctx context.Context) error {
 	fmt.Println("\nSetting up connection | SetupConnection ")
 
+	if err := cm.states.To(StateDialing); err != nil {
+		return err
+	}
 	conn, err := cm.ConnectionFactory.GetConnection()
 	if err != nil {
 		fmt.Printf("\nSetupConnection | GetConnection Error %v", err)
+		_ = cm.states.To(StateDisconnected)
 		return err
 	}
 
 	cm.ConnectionMtx.Lock()
-	defer cm.ConnectionMtx.Unlock()
-
 	cm.Connection = conn
-	err = cm.SignService.SendSignOn(cm.Connection)
+	cm.ConnectionMtx.Unlock()
+	// it was shut down while dialing.
+	if err := cm.states.To(StateSigningOn); err != nil {
+		_ = conn.Close()
+		return err
+	}
+
+	// the listener of the connection reads the 0810, the lock is released meanwhile.
+	link := &generation{cm: cm, conn: conn}
+	cm.startListener(link)
+	err = cm.SignService.SendSignOn(link)
+	// nothing but network management is sent unsigned, the link is not Ready without a working key.
+	if err == nil {
+		err = cm.SignService.SendKeyChange(link)
+	}
+	if err == nil {
+		err = cm.states.To(StateReady)
+	}
 	if err != nil {
-		fmt.Printf("\nSetupConnection | SendSignOn Error %v", err)
+		fmt.Printf("\nSetupConnection | sign on Error %v", err)
+		_ = cm.states.To(StateDisconnected)
+		cm.ConnectionMtx.Lock()
+		if cm.Connection == conn {
+			cm.Connection = nil
+		}
+		cm.ConnectionMtx.Unlock()
+		_ = conn.Close()
 		return err
 	}
 
-	connectionMsg := fmt.Sprintf("\nConnections is UP with %s", cm.Connection.RemoteAddr().String())
+	connectionMsg := fmt.Sprintf("\nConnections is UP with %s", conn.RemoteAddr().String())
 	fmt.Println(connectionMsg)
 
+	// hooks write through the connection, they run once the link is up.
+	for _, hook := range cm.connectedHooks {
+		go hook(ctx)
+	}
+
+	return nil
+}
+
+// startListener listens on link until it is disconnected.
+// When the connection fails on its own the link reconnects right away, without waiting for the heartbeat.
+func (cm *ConnManager) startListener(link *generation) {
+	tag := fmt.Sprintf(connManagerTag, "listener")
+	ctx, stopListener := context.WithCancel(context.Background())
+	cm.lifecycleMtx.Lock()
+	cm.stopListener = stopListener
+	cm.lifecycleMtx.Unlock()
+
+	go func() {
+		err := cm.Listen(ctx, link)
+		if ctx.Err() != nil {
+			return
+		}
+		fmt.Printf("\n%s | %v sending to reconnect", tag, err)
+		cm.Metrics.Inc("connection.dropped." + cm.Name)
+		cm.TryReconnect()
+	}()
+}
+
+// onTransition stops the listener once the link is disconnected, and runs the heartbeat and the scheduled
+// key changes while it is Ready.
+func (cm *ConnManager) onTransition(transition Transition) {
+	cm.lifecycleMtx.Lock()
+	defer cm.lifecycleMtx.Unlock()
+
+	if (transition.To == StateDisconnected || transition.To == StateClosed) && cm.stopListener != nil {
+		cm.stopListener()
+		cm.stopListener = nil
+	}
+	if cm.stopHeartbeat != nil {
+		cm.stopHeartbeat()
+		cm.stopHeartbeat = nil
+	}
+	if transition.To != StateReady {
+		return
+	}
+
 	heartBeatInterval := time.Duration(cm.EnvVars.HeartSendBeatIntervalSeconds) * time.Second
 	log.Println("heartBeatInterval", heartBeatInterval, cm.EnvVars.HeartSendBeatIntervalSeconds)
-	go cm.setupHeartbeat(ctx, heartBeatInterval)
+	heartbeatCtx, stopHeartbeat := context.WithCancel(context.Background())
+	cm.stopHeartbeat = stopHeartbeat
+	go cm.setupHeartbeat(heartbeatCtx, heartBeatInterval)
 
-	return nil
+	if cm.EnvVars.KeyChangeIntervalMinutes > 0 {
+		scheduler := Scheduler{Conn: cm}
+		scheduler.ScheduleTask(heartbeatCtx, cm.changeKey, time.Duration(cm.EnvVars.KeyChangeIntervalMinutes)*time.Minute)
+	}
+}
+
+// changeKey renews the working key of the link, the previous one is kept if the franchise does not answer.
+func (cm *ConnManager) changeKey(writer io.ReadWriter) {
+	if err := cm.SignService.SendKeyChange(writer); err != nil {
+		fmt.Printf("\n%s | %v", fmt.Sprintf(connManagerTag, "changeKey"), err)
+	}
+}
+
+// WorkingKey gets the MAC working key exchanged on this link.
+func (cm *ConnManager) WorkingKey() string {
+	return cm.SignService.WorkingKey()
+}
+
+// AddConnectedHook registers fn to run after every successful sign on.
+// It must be called before SetupConnection.
+func (cm *ConnManager) AddConnectedHook(fn func(context.Context)) {
+	cm.connectedHooks = append(cm.connectedHooks, fn)
+}
+
+// State gets the state of the link with the franchise.
+func (cm *ConnManager) State() State {
+	return cm.states.State()
+}
+
+// Subscribe registers fn to be called on every state transition, fn must not block.
+func (cm *ConnManager) Subscribe(fn func(Transition)) {
+	cm.states.Subscribe(fn)
+}
+
+// Shutdown drains the link, sends a sign off and closes the connection.
+// The connection is closed even if the sign off fails, and it is not set up again afterwards.
+func (cm *ConnManager) Shutdown() error {
+	tag := fmt.Sprintf(connManagerTag, "Shutdown")
+
+	var err error
+	// only a Ready link is signed on.
+	if cm.states.To(StateDraining) == nil {
+		err = cm.SignService.SendSignOff(cm)
+		if err != nil {
+			fmt.Printf("\n%s | SendSignOff Error %v", tag, err)
+		}
+	}
+	_ = cm.states.To(StateClosed)
+	cm.doneOnce.Do(func() { close(cm.done) })
+
+	if closeErr := cm.tryCloseConnection(); closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+// Ready tells if the link may take new requests.
+func (cm *ConnManager) Ready() bool {
+	return cm.State() == StateReady
+}
+
+// InFlight gets the number of requests waiting for a response on the link.
+func (cm *ConnManager) InFlight() int {
+	return int(atomic.LoadInt64(&cm.inFlight))
+}
+
+// TrackInFlight counts a request on the link until done is called.
+func (cm *ConnManager) TrackInFlight() func() {
+	atomic.AddInt64(&cm.inFlight, 1)
+	var once sync.Once
+	return func() {
+		once.Do(func() { atomic.AddInt64(&cm.inFlight, -1) })
+	}
+}
+
+// OnPrimary tells if the link is connected to the primary franchise host.
+func (cm *ConnManager) OnPrimary() bool {
+	return cm.ConnectionFactory.OnPrimary()
+}
+
+// ProbePrimary dials the primary franchise host and hangs up.
+func (cm *ConnManager) ProbePrimary() error {
+	return cm.ConnectionFactory.ProbePrimary()
+}
+
+// Failback drains the link, waiting up to FailbackDrainSeconds for its requests
+// to be answered, signs off from the backup host and reconnects to the primary one.
+func (cm *ConnManager) Failback() {
+	tag := fmt.Sprintf(connManagerTag, "Failback")
+	if err := cm.states.To(StateDraining); err != nil {
+		fmt.Printf("\n%s | %v", tag, err)
+		return
+	}
+
+	deadline := time.Now().Add(time.Duration(cm.EnvVars.FailbackDrainSeconds) * time.Second)
+	for cm.InFlight() > 0 && time.Now().Before(deadline) {
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	if err := cm.SignService.SendSignOff(cm); err != nil {
+		fmt.Printf("\n%s | SendSignOff Error %v", tag, err)
+	}
+	cm.ConnectionFactory.UsePrimary()
+	cm.TryReconnect()
+}
+
+// connected tells if the connection may be used, from sign on until it is drained.
+func (cm *ConnManager) connected() bool {
+	switch cm.State() {
+	case StateSigningOn, StateReady, StateDraining:
+		return true
+	}
+	return false
 }
 
 func (cm *ConnManager) tryCloseConnection() error {
@@ -139,8 +454,10 @@ func (cm *ConnManager) setupHeartbeat(ctx context.Context, interval time.Duratio
 	tag := fmt.Sprintf(connManagerTag, "setupHeartbeat")
 	scheduler := Scheduler{Conn: cm}
 
-	ticker := scheduler.ScheduleTask(cm.HeartbeatService.SendEchoTest, interval)
-	defer ticker.Stop()
+	// the echo tests stop along with the heartbeat, also when it gives up on the connection.
+	ctx, stop := context.WithCancel(ctx)
+	defer stop()
+	scheduler.ScheduleTask(ctx, cm.HeartbeatService.SendEchoTest, interval)
 
 	for {
 		select {
@@ -149,6 +466,8 @@ func (cm *ConnManager) setupHeartbeat(ctx context.Context, interval time.Duratio
 		case err := <-cm.HeartbeatService.GetError():
 			if err != nil {
 				fmt.Printf("\n%s | %v sending to reconnect", tag, err)
+				// the host stopped answering, the next attempt goes to the following one.
+				cm.ConnectionFactory.MarkFailed()
 				go cm.TryReconnect()
 				return
 			}
@@ -156,22 +475,50 @@ func (cm *ConnManager) setupHeartbeat(ctx context.Context, interval time.Duratio
 	}
 }
 
-// TryReconnect tries to establish a new connection with franchise.
-// If it fails, it panics.
+// TryReconnect tries to establish a new connection with franchise until it succeeds or the link is shut down.
+// Attempts are spaced by an exponential backoff with jitter, from ReconnectBackoffInitialSeconds
+// up to ReconnectBackoffMaxSeconds.
 func (cm *ConnManager) TryReconnect() {
 	tag := fmt.Sprintf(connManagerTag, "tryReconnect")
 
 	fmt.Println("\n", tag)
+	if !atomic.CompareAndSwapInt32(&cm.reconnecting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&cm.reconnecting, 0)
+	// a Closed link stays closed.
+	if err := cm.states.To(StateDisconnected); err != nil {
+		return
+	}
+
 	// try to gracefully close current connection if exists.
 	if err := cm.tryCloseConnection(); err != nil {
 		fmt.Printf("\n%s | %v close connection failed", tag, err)
 	}
 
-	// try to set up a new connection once again.
-	err := cm.SetupConnection(context.Background())
-	if err != nil {
-		fmt.Printf("\n%s Error | %v", tag, err)
-		log.Panicln(err)
+	wait := newBackoff(
+		time.Duration(cm.EnvVars.ReconnectBackoffInitialSeconds)*time.Second,
+		time.Duration(cm.EnvVars.ReconnectBackoffMaxSeconds)*time.Second,
+	)
+	for attempt := 1; ; attempt++ {
+		cm.Metrics.Inc("connection.reconnect_attempts." + cm.Name)
+		err := cm.SetupConnection(context.Background())
+		if err == nil {
+			cm.Metrics.Inc("connection.reconnects." + cm.Name)
+			return
+		}
+		if cm.State() == StateClosed {
+			return
+		}
+		cm.Metrics.Inc("connection.reconnect_failures." + cm.Name)
+
+		delay := wait.Next()
+		fmt.Printf("\n%s Error | attempt %d: %v, retrying in %s", tag, attempt, err, delay)
+		select {
+		case <-cm.done:
+			return
+		case <-time.After(delay):
+		}
 	}
 }
 
@@ -190,36 +537,52 @@ func IsNil(i interface{}) bool {
 
 // Read data from connection.
 func (cm *ConnManager) Read(b []byte) (n int, err error) {
+	if !cm.connected() {
+		return 0, ErrNoConnection
+	}
 	cm.ConnectionMtx.RLock()
 	defer cm.ConnectionMtx.RUnlock()
+	if IsNil(cm.Connection) {
+		return 0, ErrNoConnection
+	}
 	return cm.Connection.Read(b)
 }
 
-// Write data to connection.
+// Write frames data as a single message and writes it to connection.
 func (cm *ConnManager) Write(b []byte) (n int, err error) {
+	if !cm.connected() {
+		return 0, ErrNoConnection
+	}
 	cm.ConnectionMtx.RLock()
 	defer cm.ConnectionMtx.RUnlock()
-	return cm.Connection.Write(b)
+	if IsNil(cm.Connection) {
+		return 0, ErrNoConnection
+	}
+	return cm.writer.writeFrame(cm.Connection, b)
 }
 
 // Close current connection.
 func (cm *ConnManager) Close() error {
-	cm.ConnectionMtx.RLock()
-	defer cm.ConnectionMtx.RUnlock()
-	return cm.Connection.Close()
+	return cm.tryCloseConnection()
 }
 
-// LocalAddr gets local address of connection.
+// LocalAddr gets local address of connection, nil if there is none.
 func (cm *ConnManager) LocalAddr() net.Addr {
 	cm.ConnectionMtx.RLock()
 	defer cm.ConnectionMtx.RUnlock()
+	if IsNil(cm.Connection) {
+		return nil
+	}
 	return cm.Connection.LocalAddr()
 }
 
-// RemoteAddr gets remote address of connection.
+// RemoteAddr gets remote address of connection, nil if there is none.
 func (cm *ConnManager) RemoteAddr() net.Addr {
 	cm.ConnectionMtx.RLock()
 	defer cm.ConnectionMtx.RUnlock()
+	if IsNil(cm.Connection) {
+		return nil
+	}
 	return cm.Connection.RemoteAddr()
 }
 
@@ -227,6 +590,9 @@ func (cm *ConnManager) RemoteAddr() net.Addr {
 func (cm *ConnManager) SetDeadline(t time.Time) error {
 	cm.ConnectionMtx.RLock()
 	defer cm.ConnectionMtx.RUnlock()
+	if IsNil(cm.Connection) {
+		return ErrNoConnection
+	}
 	return cm.Connection.SetDeadline(t)
 }
 
@@ -234,6 +600,9 @@ func (cm *ConnManager) SetDeadline(t time.Time) error {
 func (cm *ConnManager) SetReadDeadline(t time.Time) error {
 	cm.ConnectionMtx.RLock()
 	defer cm.ConnectionMtx.RUnlock()
+	if IsNil(cm.Connection) {
+		return ErrNoConnection
+	}
 	return cm.Connection.SetReadDeadline(t)
 }
 
@@ -241,5 +610,8 @@ func (cm *ConnManager) SetReadDeadline(t time.Time) error {
 func (cm *ConnManager) SetWriteDeadline(t time.Time) error {
 	cm.ConnectionMtx.RLock()
 	defer cm.ConnectionMtx.RUnlock()
+	if IsNil(cm.Connection) {
+		return ErrNoConnection
+	}
 	return cm.Connection.SetWriteDeadline(t)
 }
@@ -0,0 +1,175 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"io"
+	"megalink/gateway/client/handler"
+	"megalink/gateway/client/metrics"
+	"megalink/gateway/client/types"
+	"megalink/gateway/shared/framing"
+	"net"
+	"sync"
+	"testing"
+)
+
+// fakeSign records the network management requests of a link.
+type fakeSign struct {
+	mtx       sync.Mutex
+	requests  []string
+	keyChange error
+	key       string
+}
+
+func (fs *fakeSign) record(request string) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	fs.requests = append(fs.requests, request)
+}
+
+func (fs *fakeSign) SendSignOn(io.Writer) error  { fs.record("sign on"); return nil }
+func (fs *fakeSign) SendSignOff(io.Writer) error { fs.record("sign off"); return nil }
+
+func (fs *fakeSign) SendKeyChange(io.Writer) error {
+	fs.record("key change")
+	if fs.keyChange != nil {
+		return fs.keyChange
+	}
+	fs.SetWorkingKey("0123456789ABCDEF")
+	return nil
+}
+
+func (fs *fakeSign) WorkingKey() string {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	return fs.key
+}
+
+func (fs *fakeSign) SetWorkingKey(key string) {
+	fs.mtx.Lock()
+	defer fs.mtx.Unlock()
+	fs.key = key
+}
+
+func (fs *fakeSign) HandleSignResponse(next handler.MessageHandlerFunc) handler.MessageHandlerFunc {
+	return next
+}
+
+// fakeHeartbeat never sends nor fails.
+type fakeHeartbeat struct{}
+
+func (fakeHeartbeat) SendEchoTest(io.ReadWriter) {}
+func (fakeHeartbeat) HandleHeartBeatResponse(next handler.MessageHandlerFunc) handler.MessageHandlerFunc {
+	return next
+}
+func (fakeHeartbeat) GetError() <-chan error { return nil }
+
+// pipeFactory connects to the end of a pipe kept by the test.
+type pipeFactory struct {
+	IConnFactory
+	dialErr error
+	hosts   []net.Conn
+}
+
+func (pf *pipeFactory) GetConnection() (net.Conn, error) {
+	if pf.dialErr != nil {
+		return nil, pf.dialErr
+	}
+	conn, host := net.Pipe()
+	pf.hosts = append(pf.hosts, host)
+	return conn, nil
+}
+
+func newTestConnManager(t *testing.T, sign *fakeSign, factory *pipeFactory) *ConnManager {
+	t.Helper()
+	framer, err := framing.NewFramer(framing.Binary4, "")
+	if err != nil {
+		t.Fatalf("NewFramer: %v", err)
+	}
+	envVars := &types.EnvVars{HeartSendBeatIntervalSeconds: 3600, KeyChangeIntervalMinutes: 60}
+	listen := func(ctx context.Context, _ io.ReadWriter) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	cm := NewConnManager(sign, fakeHeartbeat{}, factory, framer, envVars, metrics.NewMetrics(), "visa", listen)
+	t.Cleanup(func() {
+		_ = cm.Shutdown()
+		for _, host := range factory.hosts {
+			_ = host.Close()
+		}
+	})
+	return cm.(*ConnManager)
+}
+
+func TestSetupConnectionSignsOnAndChangesKey(t *testing.T) {
+	sign := &fakeSign{}
+	cm := newTestConnManager(t, sign, &pipeFactory{})
+
+	hooked := make(chan struct{})
+	cm.AddConnectedHook(func(context.Context) { close(hooked) })
+	if err := cm.SetupConnection(context.Background()); err != nil {
+		t.Fatalf("SetupConnection: %v", err)
+	}
+	<-hooked
+
+	if cm.State() != StateReady {
+		t.Errorf("State = %s, want ready", cm.State())
+	}
+	if cm.WorkingKey() != "0123456789ABCDEF" {
+		t.Errorf("WorkingKey = %q, want the key of the key change", cm.WorkingKey())
+	}
+	if want := []string{"sign on", "key change"}; len(sign.requests) != 2 ||
+		sign.requests[0] != want[0] || sign.requests[1] != want[1] {
+		t.Errorf("requests %v, want %v", sign.requests, want)
+	}
+}
+
+func TestSetupConnectionFailures(t *testing.T) {
+	errDial := errors.New("connection refused")
+	errKey := errors.New("key change rejected")
+	tests := []struct {
+		name    string
+		sign    *fakeSign
+		factory *pipeFactory
+		wantErr error
+	}{
+		{name: "dial", sign: &fakeSign{}, factory: &pipeFactory{dialErr: errDial}, wantErr: errDial},
+		{name: "no working key", sign: &fakeSign{keyChange: errKey}, factory: &pipeFactory{}, wantErr: errKey},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cm := newTestConnManager(t, tt.sign, tt.factory)
+			if err := cm.SetupConnection(context.Background()); !errors.Is(err, tt.wantErr) {
+				t.Fatalf("SetupConnection error = %v, want %v", err, tt.wantErr)
+			}
+			if cm.State() != StateDisconnected {
+				t.Errorf("State = %s, want disconnected", cm.State())
+			}
+			if _, err := cm.Write([]byte("0200")); !errors.Is(err, ErrNoConnection) {
+				t.Errorf("Write error = %v, want ErrNoConnection", err)
+			}
+		})
+	}
+}
+
+func TestShutdownSignsOffAndCloses(t *testing.T) {
+	sign := &fakeSign{}
+	cm := newTestConnManager(t, sign, &pipeFactory{})
+	if err := cm.SetupConnection(context.Background()); err != nil {
+		t.Fatalf("SetupConnection: %v", err)
+	}
+
+	if err := cm.Shutdown(); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+	if cm.State() != StateClosed {
+		t.Errorf("State = %s, want closed", cm.State())
+	}
+	if last := sign.requests[len(sign.requests)-1]; last != "sign off" {
+		t.Errorf("last request %q, want sign off", last)
+	}
+	if err := cm.SetupConnection(context.Background()); !errors.Is(err, ErrInvalidTransition) {
+		t.Errorf("SetupConnection after Shutdown error = %v, want ErrInvalidTransition", err)
+	}
+}
@@ -0,0 +1,304 @@
+package connection
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"megalink/gateway/client/types"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	poolTag = "Pool | %s"
+
+	// SelectRoundRobin hands requests to the ready links in turn.
+	SelectRoundRobin = "round-robin"
+	// SelectLeastInFlight hands requests to the ready link with fewer requests waiting for a response.
+	SelectLeastInFlight = "least-in-flight"
+)
+
+var (
+	// ErrPoolRead triggered when reading from a pool, every link has its own listener.
+	ErrPoolRead = errors.New("read from a pool, listen on its links")
+)
+
+type (
+	// IPool is an IConnManager spreading writes over parallel links with the same franchise.
+	IPool interface {
+		IConnManager
+		// Pick gets a ready link for a request, done must be called once its response arrived.
+		Pick() (IPoolMember, func(), error)
+	}
+
+	// Pool implements IPool, every link has its own listener, sign on, working key and heartbeat.
+	// Messages are signed with the key of the link they go through, so they are sent to a picked link.
+	// A link which is not ready is left out of rotation until it signs on again.
+	Pool struct {
+		Members   []IPoolMember
+		Selection string
+		EnvVars   *types.EnvVars
+		next      uint32
+		// stateMtx guards stopProbe.
+		stateMtx  sync.Mutex
+		stopProbe context.CancelFunc
+	}
+)
+
+// NewPool initializes a new IPool with members, selection defaults to round robin.
+func NewPool(members []IPoolMember, selection string, envVars *types.EnvVars) (IPool, error) {
+	if len(members) == 0 {
+		return nil, errors.New("pool without links")
+	}
+	switch selection {
+	case "":
+		selection = SelectRoundRobin
+	case SelectRoundRobin, SelectLeastInFlight:
+	default:
+		return nil, fmt.Errorf("unknown link selection %q", selection)
+	}
+
+	return &Pool{
+		Members:   members,
+		Selection: selection,
+		EnvVars:   envVars,
+	}, nil
+}
+
+// Pick gets a ready link for a request, done must be called once its response arrived.
+func (p *Pool) Pick() (IPoolMember, func(), error) {
+	ready := make([]IPoolMember, 0, len(p.Members))
+	for _, member := range p.Members {
+		if member.Ready() {
+			ready = append(ready, member)
+		}
+	}
+	if len(ready) == 0 {
+		return nil, nil, ErrNoConnection
+	}
+
+	start := int(atomic.AddUint32(&p.next, 1) % uint32(len(ready)))
+	picked := ready[start]
+	if p.Selection == SelectLeastInFlight {
+		// scanning from the round robin turn breaks ties evenly.
+		for i := 1; i < len(ready); i++ {
+			member := ready[(start+i)%len(ready)]
+			if member.InFlight() < picked.InFlight() {
+				picked = member
+			}
+		}
+	}
+
+	return picked, picked.TrackInFlight(), nil
+}
+
+// SetupConnection sets up every link and starts probing the primary host.
+// A link which could not be set up keeps reconnecting, it fails only when no link is up.
+func (p *Pool) SetupConnection(ctx context.Context) error {
+	var errs []error
+	for _, member := range p.Members {
+		if err := member.SetupConnection(ctx); err != nil {
+			errs = append(errs, err)
+			go member.TryReconnect()
+		}
+	}
+
+	p.stateMtx.Lock()
+	if p.stopProbe == nil && p.EnvVars.PrimaryProbeIntervalSeconds > 0 {
+		probeCtx, stopProbe := context.WithCancel(ctx)
+		p.stopProbe = stopProbe
+		go p.watchPrimary(probeCtx, time.Duration(p.EnvVars.PrimaryProbeIntervalSeconds)*time.Second)
+	}
+	p.stateMtx.Unlock()
+
+	if len(errs) == len(p.Members) {
+		return errors.Join(errs...)
+	}
+	return nil
+}
+
+// watchPrimary probes the primary host while some link is on a backup one.
+// After FailbackHealthyProbes probes in a row succeed the links fail back one at a time,
+// so the others keep taking requests meanwhile.
+func (p *Pool) watchPrimary(ctx context.Context, interval time.Duration) {
+	tag := fmt.Sprintf(poolTag, "watchPrimary")
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	healthy := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		var onBackup []IPoolMember
+		for _, member := range p.Members {
+			if member.Ready() && !member.OnPrimary() {
+				onBackup = append(onBackup, member)
+			}
+		}
+		if len(onBackup) == 0 {
+			healthy = 0
+			continue
+		}
+
+		if err := onBackup[0].ProbePrimary(); err != nil {
+			healthy = 0
+			continue
+		}
+		healthy++
+		if healthy < p.EnvVars.FailbackHealthyProbes {
+			continue
+		}
+
+		fmt.Printf("\n%s | primary host healthy, failing back %d links", tag, len(onBackup))
+		for _, member := range onBackup {
+			if ctx.Err() != nil {
+				return
+			}
+			member.Failback()
+		}
+		healthy = 0
+	}
+}
+
+// statePriority orders the states of the links, the pool is in the first one any link is.
+var statePriority = []State{StateReady, StateSigningOn, StateDialing, StateDraining, StateDisconnected, StateClosed}
+
+// State gets the most advanced state among the links, Ready if any of them is.
+func (p *Pool) State() State {
+	states := make(map[State]bool, len(statePriority))
+	for _, member := range p.Members {
+		states[member.State()] = true
+	}
+	for _, state := range statePriority {
+		if states[state] {
+			return state
+		}
+	}
+	return StateDisconnected
+}
+
+// Subscribe registers fn to be called on every state transition of any link, fn must not block.
+func (p *Pool) Subscribe(fn func(Transition)) {
+	for _, member := range p.Members {
+		member.Subscribe(fn)
+	}
+}
+
+// CloseConnection closes every link.
+func (p *Pool) CloseConnection() error {
+	var errs []error
+	for _, member := range p.Members {
+		if err := member.CloseConnection(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// TryReconnect reconnects the links out of rotation.
+func (p *Pool) TryReconnect() {
+	for _, member := range p.Members {
+		if !member.Ready() {
+			go member.TryReconnect()
+		}
+	}
+}
+
+// AddConnectedHook registers fn to run after every successful sign on of any link.
+// It must be called before SetupConnection.
+func (p *Pool) AddConnectedHook(fn func(context.Context)) {
+	for _, member := range p.Members {
+		member.AddConnectedHook(fn)
+	}
+}
+
+// Shutdown stops probing the primary host and shuts every link down.
+func (p *Pool) Shutdown() error {
+	p.stateMtx.Lock()
+	stopProbe := p.stopProbe
+	p.stateMtx.Unlock()
+	if stopProbe != nil {
+		stopProbe()
+	}
+
+	var errs []error
+	for _, member := range p.Members {
+		if err := member.Shutdown(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Read is not supported, every link has its own listener.
+func (p *Pool) Read(_ []byte) (int, error) {
+	return 0, ErrPoolRead
+}
+
+// Write writes a single message to a ready link.
+func (p *Pool) Write(b []byte) (int, error) {
+	member, done, err := p.Pick()
+	if err != nil {
+		return 0, err
+	}
+	defer done()
+	return member.Write(b)
+}
+
+// Close closes every link.
+func (p *Pool) Close() error {
+	return p.CloseConnection()
+}
+
+// LocalAddr gets local address of a ready link, nil if none is.
+func (p *Pool) LocalAddr() net.Addr {
+	for _, member := range p.Members {
+		if member.Ready() {
+			return member.LocalAddr()
+		}
+	}
+	return nil
+}
+
+// RemoteAddr gets remote address of a ready link, nil if none is.
+func (p *Pool) RemoteAddr() net.Addr {
+	for _, member := range p.Members {
+		if member.Ready() {
+			return member.RemoteAddr()
+		}
+	}
+	return nil
+}
+
+// SetDeadline sets a deadline on every ready link.
+func (p *Pool) SetDeadline(t time.Time) error {
+	return p.eachReady(func(member IPoolMember) error { return member.SetDeadline(t) })
+}
+
+// SetReadDeadline sets read deadline on every ready link.
+func (p *Pool) SetReadDeadline(t time.Time) error {
+	return p.eachReady(func(member IPoolMember) error { return member.SetReadDeadline(t) })
+}
+
+// SetWriteDeadline sets write deadline on every ready link.
+func (p *Pool) SetWriteDeadline(t time.Time) error {
+	return p.eachReady(func(member IPoolMember) error { return member.SetWriteDeadline(t) })
+}
+
+func (p *Pool) eachReady(fn func(IPoolMember) error) error {
+	var errs []error
+	for _, member := range p.Members {
+		if member.Ready() {
+			if err := fn(member); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return errors.Join(errs...)
+}
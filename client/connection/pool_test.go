@@ -0,0 +1,150 @@
+package connection
+
+import (
+	"errors"
+	"megalink/gateway/client/types"
+	"testing"
+)
+
+// fakeMember is a link of a pool in a given state.
+type fakeMember struct {
+	IPoolMember
+	name     string
+	state    State
+	inFlight int
+}
+
+func (fm *fakeMember) Ready() bool   { return fm.state == StateReady }
+func (fm *fakeMember) State() State  { return fm.state }
+func (fm *fakeMember) InFlight() int { return fm.inFlight }
+
+func (fm *fakeMember) TrackInFlight() func() {
+	fm.inFlight++
+	return func() { fm.inFlight-- }
+}
+
+func newTestPool(t *testing.T, selection string, members ...*fakeMember) IPool {
+	t.Helper()
+	links := make([]IPoolMember, 0, len(members))
+	for _, member := range members {
+		links = append(links, member)
+	}
+	pool, err := NewPool(links, selection, &types.EnvVars{})
+	if err != nil {
+		t.Fatalf("NewPool: %v", err)
+	}
+	return pool
+}
+
+func TestPoolPick(t *testing.T) {
+	tests := []struct {
+		name      string
+		selection string
+		members   []*fakeMember
+		want      []string
+	}{
+		{
+			name: "round robin skips links not ready",
+			members: []*fakeMember{
+				{name: "a", state: StateReady},
+				{name: "b", state: StateSigningOn},
+				{name: "c", state: StateReady},
+			},
+			want: []string{"c", "a", "c", "a"},
+		},
+		{
+			name:      "least in flight",
+			selection: SelectLeastInFlight,
+			members: []*fakeMember{
+				{name: "a", state: StateReady, inFlight: 3},
+				{name: "b", state: StateReady, inFlight: 1},
+				{name: "c", state: StateDraining},
+			},
+			// picked requests are not done, so they count.
+			want: []string{"b", "b", "b", "a"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pool := newTestPool(t, tt.selection, tt.members...)
+			for i, want := range tt.want {
+				member, _, err := pool.Pick()
+				if err != nil {
+					t.Fatalf("Pick #%d: %v", i, err)
+				}
+				if got := member.(*fakeMember).name; got != want {
+					t.Errorf("Pick #%d = %s, want %s", i, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestPoolPickTracksInFlight(t *testing.T) {
+	member := &fakeMember{name: "a", state: StateReady}
+	pool := newTestPool(t, "", member)
+
+	_, done, err := pool.Pick()
+	if err != nil {
+		t.Fatalf("Pick: %v", err)
+	}
+	if member.inFlight != 1 {
+		t.Errorf("in flight = %d, want 1", member.inFlight)
+	}
+	done()
+	if member.inFlight != 0 {
+		t.Errorf("in flight after done = %d, want 0", member.inFlight)
+	}
+}
+
+func TestPoolWithoutReadyLinks(t *testing.T) {
+	pool := newTestPool(t, "",
+		&fakeMember{name: "a", state: StateDisconnected},
+		&fakeMember{name: "b", state: StateDialing},
+	)
+
+	if _, _, err := pool.Pick(); !errors.Is(err, ErrNoConnection) {
+		t.Errorf("Pick error = %v, want ErrNoConnection", err)
+	}
+	if _, err := pool.Write([]byte("0200")); !errors.Is(err, ErrNoConnection) {
+		t.Errorf("Write error = %v, want ErrNoConnection", err)
+	}
+	if _, err := pool.Read(make([]byte, 4)); !errors.Is(err, ErrPoolRead) {
+		t.Errorf("Read error = %v, want ErrPoolRead", err)
+	}
+}
+
+func TestPoolState(t *testing.T) {
+	tests := []struct {
+		name   string
+		states []State
+		want   State
+	}{
+		{name: "any ready", states: []State{StateDisconnected, StateReady, StateDraining}, want: StateReady},
+		{name: "signing on", states: []State{StateDialing, StateSigningOn}, want: StateSigningOn},
+		{name: "draining before down", states: []State{StateDisconnected, StateDraining}, want: StateDraining},
+		{name: "all closed", states: []State{StateClosed, StateClosed}, want: StateClosed},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			members := make([]*fakeMember, 0, len(tt.states))
+			for _, state := range tt.states {
+				members = append(members, &fakeMember{state: state})
+			}
+			if got := newTestPool(t, "", members...).State(); got != tt.want {
+				t.Errorf("State = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewPoolValidation(t *testing.T) {
+	if _, err := NewPool(nil, "", &types.EnvVars{}); err == nil {
+		t.Error("NewPool without links succeeded, want error")
+	}
+	if _, err := NewPool([]IPoolMember{&fakeMember{}}, "random", &types.EnvVars{}); err == nil {
+		t.Error("NewPool with an unknown selection succeeded, want error")
+	}
+}
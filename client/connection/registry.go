@@ -0,0 +1,54 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+var (
+	// ErrUnknownConnection triggered when asking for a connection which was not registered.
+	ErrUnknownConnection = errors.New("unknown franchise connection")
+)
+
+// Registry keeps the connection with every franchise by name.
+type Registry struct {
+	mtx   sync.RWMutex
+	conns map[string]IPool
+	names []string
+}
+
+// NewRegistry provides an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{conns: make(map[string]IPool)}
+}
+
+// Add registers pool under name.
+func (r *Registry) Add(name string, pool IPool) error {
+	r.mtx.Lock()
+	defer r.mtx.Unlock()
+	if _, ok := r.conns[name]; ok {
+		return fmt.Errorf("franchise connection %q already registered", name)
+	}
+	r.conns[name] = pool
+	r.names = append(r.names, name)
+	return nil
+}
+
+// Get gets the connection registered under name.
+func (r *Registry) Get(name string) (IPool, error) {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	pool, ok := r.conns[name]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownConnection, name)
+	}
+	return pool, nil
+}
+
+// Names gets the registered names in registration order.
+func (r *Registry) Names() []string {
+	r.mtx.RLock()
+	defer r.mtx.RUnlock()
+	return append([]string(nil), r.names...)
+}
@@ -0,0 +1,112 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// State of a link with the franchise.
+type State string
+
+const (
+	// StateDisconnected no connection, a reconnect may be waiting for its next attempt.
+	StateDisconnected State = "disconnected"
+	// StateDialing dialing the franchise hosts.
+	StateDialing State = "dialing"
+	// StateSigningOn connected and waiting for the sign on response.
+	StateSigningOn State = "signing-on"
+	// StateReady signed on and taking requests.
+	StateReady State = "ready"
+	// StateDraining taking no new requests while the ones sent are answered, before signing off.
+	StateDraining State = "draining"
+	// StateClosed shut down for good.
+	StateClosed State = "closed"
+)
+
+var (
+	// ErrInvalidTransition triggered when a link is asked to move to a state not reachable from its current one.
+	ErrInvalidTransition = errors.New("invalid connection state transition")
+
+	// transitions allowed from every state, any state but Closed may go back to Disconnected.
+	transitions = map[State][]State{
+		StateDisconnected: {StateDialing, StateClosed},
+		StateDialing:      {StateSigningOn, StateDisconnected, StateClosed},
+		StateSigningOn:    {StateReady, StateDisconnected, StateClosed},
+		StateReady:        {StateDraining, StateDisconnected, StateClosed},
+		StateDraining:     {StateDisconnected, StateClosed},
+		StateClosed:       {},
+	}
+)
+
+// Transition is a change of state of the link with a franchise.
+type Transition struct {
+	// Name of the franchise.
+	Name string
+	From State
+	To   State
+	At   time.Time
+}
+
+// stateMachine keeps the state of a link and tells its subscribers about every transition, in order.
+type stateMachine struct {
+	name  string
+	mtx   sync.Mutex
+	state State
+	// notifyMtx keeps transitions in order for subscribers, which may read the state meanwhile.
+	notifyMtx   sync.Mutex
+	subscribers []func(Transition)
+}
+
+func newStateMachine(name string) *stateMachine {
+	return &stateMachine{name: name, state: StateDisconnected}
+}
+
+// State gets the current state.
+func (sm *stateMachine) State() State {
+	sm.mtx.Lock()
+	defer sm.mtx.Unlock()
+	return sm.state
+}
+
+// Subscribe registers fn to be called on every transition, fn must not block.
+func (sm *stateMachine) Subscribe(fn func(Transition)) {
+	sm.notifyMtx.Lock()
+	defer sm.notifyMtx.Unlock()
+	sm.subscribers = append(sm.subscribers, fn)
+}
+
+// To moves to state, staying in the current state is not a transition.
+func (sm *stateMachine) To(state State) error {
+	sm.notifyMtx.Lock()
+	defer sm.notifyMtx.Unlock()
+
+	sm.mtx.Lock()
+	from := sm.state
+	if from == state {
+		sm.mtx.Unlock()
+		return nil
+	}
+	if !canTransition(from, state) {
+		sm.mtx.Unlock()
+		return fmt.Errorf("%w from %s to %s", ErrInvalidTransition, from, state)
+	}
+	sm.state = state
+	sm.mtx.Unlock()
+
+	transition := Transition{Name: sm.name, From: from, To: state, At: time.Now()}
+	for _, fn := range sm.subscribers {
+		fn(transition)
+	}
+	return nil
+}
+
+func canTransition(from, to State) bool {
+	for _, allowed := range transitions[from] {
+		if allowed == to {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,75 @@
+package connection
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestStateMachineTransitions(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    []State
+		wantErr bool
+	}{
+		{name: "sign on", path: []State{StateDialing, StateSigningOn, StateReady}},
+		{name: "drain and close", path: []State{StateDialing, StateSigningOn, StateReady, StateDraining, StateClosed}},
+		{name: "dial failure", path: []State{StateDialing, StateDisconnected, StateDialing}},
+		{name: "dropped while ready", path: []State{StateDialing, StateSigningOn, StateReady, StateDisconnected}},
+		{name: "ready without sign on", path: []State{StateDialing, StateReady}, wantErr: true},
+		{name: "draining back to ready", path: []State{StateDialing, StateSigningOn, StateReady, StateDraining, StateReady}, wantErr: true},
+		{name: "closed for good", path: []State{StateClosed, StateDialing}, wantErr: true},
+		{name: "closed stays disconnected", path: []State{StateClosed, StateDisconnected}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sm := newStateMachine("visa")
+			var err error
+			for _, state := range tt.path {
+				if err = sm.To(state); err != nil {
+					break
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("To error = %v, want error %v", err, tt.wantErr)
+			}
+			if err != nil && !errors.Is(err, ErrInvalidTransition) {
+				t.Errorf("To error = %v, want ErrInvalidTransition", err)
+			}
+			if !tt.wantErr && sm.State() != tt.path[len(tt.path)-1] {
+				t.Errorf("State = %s, want %s", sm.State(), tt.path[len(tt.path)-1])
+			}
+		})
+	}
+}
+
+func TestStateMachineNotifiesInOrder(t *testing.T) {
+	sm := newStateMachine("visa")
+	var first, second []Transition
+	sm.Subscribe(func(tr Transition) { first = append(first, tr) })
+	sm.Subscribe(func(tr Transition) { second = append(second, tr) })
+
+	for _, state := range []State{StateDialing, StateDialing, StateSigningOn, StateReady} {
+		_ = sm.To(state)
+	}
+	_ = sm.To(StateSigningOn)
+
+	want := []Transition{
+		{Name: "visa", From: StateDisconnected, To: StateDialing},
+		{Name: "visa", From: StateDialing, To: StateSigningOn},
+		{Name: "visa", From: StateSigningOn, To: StateReady},
+	}
+	for _, got := range [][]Transition{first, second} {
+		if len(got) != len(want) {
+			t.Fatalf("got %d transitions, want %d", len(got), len(want))
+		}
+		for i := range want {
+			if got[i].Name != want[i].Name || got[i].From != want[i].From || got[i].To != want[i].To {
+				t.Errorf("transition #%d = %+v, want %+v", i, got[i], want[i])
+			}
+			if got[i].At.IsZero() {
+				t.Errorf("transition #%d without time", i)
+			}
+		}
+	}
+}
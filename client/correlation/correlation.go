@@ -0,0 +1,32 @@
+// Package correlation matches franchise responses with their requests.
+package correlation
+
+import (
+	"megalink/gateway/shared"
+	"strings"
+)
+
+const (
+	keySeparator = "|"
+)
+
+// DefaultFields terminal ID, STAN and RRN, unique for a terminal within a business day.
+var DefaultFields = []int{41, 11, 37}
+
+// KeyFunc builds the key shared by a request and its response.
+type KeyFunc func(tx *shared.Transaction) string
+
+// NewKeyFunc provides a KeyFunc joining the given data elements, DefaultFields if none.
+func NewKeyFunc(fields []int) KeyFunc {
+	if len(fields) == 0 {
+		fields = DefaultFields
+	}
+
+	return func(tx *shared.Transaction) string {
+		parts := make([]string, len(fields))
+		for i, n := range fields {
+			parts[i] = strings.TrimSpace(tx.Field(n))
+		}
+		return strings.Join(parts, keySeparator)
+	}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"megalink/gateway/client/channels"
+	"megalink/gateway/client/connection"
+	"megalink/gateway/client/correlation"
+	"megalink/gateway/client/handler"
+	heartbeatService "megalink/gateway/client/heartbeat"
+	"megalink/gateway/client/inflight"
+	"megalink/gateway/client/listener"
+	"megalink/gateway/client/metrics"
+	"megalink/gateway/client/saf"
+	"megalink/gateway/client/sender"
+	"megalink/gateway/client/sequence"
+	"megalink/gateway/client/sign"
+	"megalink/gateway/client/types"
+	"megalink/gateway/logger"
+	"megalink/gateway/shared"
+	"megalink/gateway/shared/codec"
+	"megalink/gateway/shared/framing"
+	"time"
+)
+
+// franchiseDeps are shared by the links with every franchise.
+type franchiseDeps struct {
+	EnvVars        *types.EnvVars
+	Logger         logger.IFastLogger
+	Metrics        metrics.IMetrics
+	STAN           sequence.ISTANGenerator
+	Pending        *channels.Registry[*shared.Transaction]
+	CorrelationKey correlation.KeyFunc
+	RespHandler    handler.ResponseHandler
+	ErrHandler     handler.ErrorHandler
+}
+
+// franchiseSetup is what the gateway uses to reach a franchise, its wire format may differ from the others.
+type franchiseSetup struct {
+	Codec   codec.Codec
+	Framer  framing.Framer
+	Send    sender.SendFunc
+	Pool    connection.IPool
+	Queue   saf.IQueue
+	Limiter inflight.ILimiter
+}
+
+// setupFranchise connects to franchise over a pool of links sharing its store-and-forward queue.
+// Every link has its own sign on, working key, heartbeat and listener, the codec, framing and in-flight window
+// are the franchise ones.
+func setupFranchise(
+	ctx context.Context,
+	deps *franchiseDeps,
+	franchise types.Franchise,
+) (*franchiseSetup, error) {
+	fs := &franchiseSetup{}
+	var err error
+	fs.Codec, err = codec.NewCodec(
+		orDefault(franchise.MessageCodec, deps.EnvVars.MessageCodec),
+		orDefault(franchise.FieldSpecPath, deps.EnvVars.FieldSpecPath),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", franchise.Name, err)
+	}
+	fs.Framer, err = framing.NewFramer(
+		orDefault(franchise.Framing, deps.EnvVars.Framing),
+		orDefault(franchise.TPDU, deps.EnvVars.TPDU),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", franchise.Name, err)
+	}
+
+	// every message to the franchise goes through the sender chain, the MAC key comes from the link sign on.
+	fs.Send = new(sender.SenderChain).
+		AddMiddleware(sender.Logging(deps.Logger)).
+		AddMiddleware(sender.Metrics(deps.Metrics)).
+		AddMiddleware(sender.DefaultFields(deps.EnvVars)).
+		AddMiddleware(sender.AssignSTAN(deps.STAN, deps.EnvVars.TerminalID)).
+		AddMiddleware(sender.MAC(fs.Codec)).
+		BuildChain(sender.Encode(fs.Codec))
+
+	// the window is the franchise one, a slow franchise does not hold the others back.
+	fs.Limiter = inflight.NewLimiter(
+		franchise.Name,
+		deps.EnvVars.MaxInFlight,
+		deps.EnvVars.InFlightQueueSize,
+		time.Duration(deps.EnvVars.InFlightQueueTimeoutSeconds)*time.Second,
+		deps.Metrics,
+	)
+
+	links := franchise.Connections
+	if links < 1 {
+		links = 1
+	}
+	members := make([]connection.IPoolMember, 0, links)
+	for i := 0; i < links; i++ {
+		member, err := setupLink(deps, fs, franchise)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", franchise.Name, err)
+		}
+		members = append(members, member)
+	}
+	fs.Pool, err = connection.NewPool(members, franchise.Selection, deps.EnvVars)
+	if err != nil {
+		return nil, err
+	}
+
+	// the queue carries card data, it is never written without a key.
+	var safKey []byte
+	if franchise.SAFStorePath != "" {
+		if deps.EnvVars.SAFEncryptionKey == "" {
+			return nil, fmt.Errorf("%s: SAF store %s without SAF encryption key", franchise.Name, franchise.SAFStorePath)
+		}
+		safKey, err = saf.ParseKey(deps.EnvVars.SAFEncryptionKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", franchise.Name, err)
+		}
+	}
+
+	fs.Queue, err = saf.NewQueue(
+		franchise.SAFStorePath,
+		safKey,
+		deps.EnvVars.SAFMaxRetries,
+		time.Duration(deps.EnvVars.SAFResponseWaitSeconds)*time.Second,
+		func() (io.Writer, func(), error) {
+			return fs.Pool.Pick()
+		},
+		fs.Send,
+		deps.Pending,
+		deps.CorrelationKey,
+		deps.Logger,
+	)
+	if err != nil {
+		return nil, err
+	}
+	fs.Pool.AddConnectedHook(fs.Queue.Drain)
+	fs.Pool.Subscribe(func(transition connection.Transition) {
+		deps.Logger.Info("Connection", fmt.Sprintf("%s %s -> %s", transition.Name, transition.From, transition.To))
+		deps.Metrics.Inc("connection.transitions." + transition.Name + "." + string(transition.To))
+	})
+
+	_ = fs.Pool.SetupConnection(ctx)
+
+	return fs, nil
+}
+
+// setupLink builds a link with franchise listening on every connection it makes, it is set up by its pool.
+func setupLink(
+	deps *franchiseDeps,
+	fs *franchiseSetup,
+	franchise types.Franchise,
+) (connection.IPoolMember, error) {
+	connFact := connection.NewConnFactory(deps.EnvVars, franchise.Addresses)
+	heartbeat := heartbeatService.NewHeartBeatService(deps.EnvVars, deps.Logger, fs.Send)
+
+	// the sign on and working key are the link ones, so is a key pushed by the host over it.
+	signService := sign.NewSignService(deps.EnvVars, fs.Send)
+	hostRequestHandler := handler.NewRequestHandler(fs.Send, signService.SetWorkingKey, func() {
+		deps.Logger.Info("Main", franchise.Name+" business day cutover")
+	})
+
+	// network management responses are told apart by F70.
+	networkResponses := new(listener.ListenerChain).
+		AddHandler(heartbeat.HandleHeartBeatResponse).
+		AddHandler(signService.HandleSignResponse).
+		BuildChain()
+
+	mtiRouter := handler.NewRouter(deps.Metrics)
+	routes := []struct {
+		pattern string
+		handler handler.MessageHandlerFunc
+	}{
+		{"0810", networkResponses},
+		{"0x00", hostRequestHandler.HandleHostRequest},
+		{"0x20", hostRequestHandler.HandleHostRequest},
+		{"0x10", deps.RespHandler.HandleMessageResponse(mtiRouter.Default)},
+		{"0x30", deps.RespHandler.HandleMessageResponse(mtiRouter.Default)},
+	}
+	for _, rt := range routes {
+		if err := mtiRouter.Handle(rt.pattern, rt.handler); err != nil {
+			return nil, err
+		}
+	}
+
+	dataFastHandler := new(listener.ListenerChain).
+		AddHandler(deps.ErrHandler.HandleMessageError).
+		AddHandler(mtiRouter.Route).
+		BuildChain()
+
+	// Listen for response on every connection, from dialing until it is dropped.
+	listen := func(ctx context.Context, conn io.ReadWriter) error {
+		return listener.NewListener(conn, dataFastHandler, deps.ErrHandler, fs.Codec, fs.Framer, deps.EnvVars).Listen(ctx)
+	}
+
+	connManager := connection.NewConnManager(
+		signService, heartbeat, connFact, fs.Framer, deps.EnvVars, deps.Metrics, franchise.Name, listen,
+	)
+
+	return connManager, nil
+}
+
+// orDefault gets value, or the gateway wide fallback if it is empty.
+func orDefault(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
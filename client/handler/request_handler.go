@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"fmt"
+	"io"
+	"megalink/gateway/client/network"
+	"megalink/gateway/client/sender"
+	"megalink/gateway/shared"
+	"strings"
+)
+
+const (
+	// function digit of advices, acknowledged rather than declined.
+	functionAdvice = '2'
+	// mtiReversalAdvice prefix of the 0420 reversal advices and their 0421 repeats.
+	mtiReversalAdvice = "042"
+	// responseNotProcessed F39 of an advice the gateway cannot process yet, so the host sends it again.
+	responseNotProcessed = "96"
+)
+
+type (
+	// RequestHandler defines handling methods of requests initiated by the franchise.
+	RequestHandler interface {
+		HandleHostRequest(writer io.ReadWriter, request *shared.Transaction) error
+	}
+	// ListenerRequestHandler answers franchise requests through the connection they came from.
+	ListenerRequestHandler struct {
+		// Send writes replies through the sender chain.
+		Send sender.SendFunc
+		// KeyChange receives the working key of a host key change, ignored if nil.
+		KeyChange func(key string)
+		// Cutover is called when the host notifies a business day cutover, ignored if nil.
+		Cutover func()
+	}
+)
+
+// NewRequestHandler provides a RequestHandler.
+func NewRequestHandler(
+	send sender.SendFunc,
+	keyChange func(key string),
+	cutover func(),
+) RequestHandler {
+	return &ListenerRequestHandler{
+		Send:      send,
+		KeyChange: keyChange,
+		Cutover:   cutover,
+	}
+}
+
+// HandleHostRequest replies to requests, advices and notifications sent by the franchise.
+func (lrh *ListenerRequestHandler) HandleHostRequest(writer io.ReadWriter, request *shared.Transaction) error {
+	if err := lrh.Send(writer, lrh.respond(request)); err != nil {
+		return fmt.Errorf("replying host MTI %s: %w", request.MTI, err)
+	}
+	return nil
+}
+
+// respond builds the answer of a host request.
+func (lrh *ListenerRequestHandler) respond(request *shared.Transaction) *shared.Transaction {
+	if request.MTI != network.MTIRequest {
+		return lrh.respondOther(request)
+	}
+
+	switch request.F70 {
+	case network.CodeEcho:
+		return network.NewResponse(request, network.ResponseApproved)
+	case network.CodeKeyChange:
+		if request.F48 == "" {
+			return network.NewResponse(request, network.ResponseInvalid)
+		}
+		if lrh.KeyChange != nil {
+			lrh.KeyChange(request.F48)
+		}
+		return network.NewResponse(request, network.ResponseApproved)
+	case network.CodeCutover:
+		if lrh.Cutover != nil {
+			lrh.Cutover()
+		}
+		return network.NewResponse(request, network.ResponseApproved)
+	default:
+		fmt.Printf("\nHandleHostRequest | unsupported F70 %s", request.F70)
+		return network.NewResponse(request, network.ResponseInvalid)
+	}
+}
+
+// respondOther acknowledges advices and declines any other request the gateway does not serve.
+// Only the fields echoed on every response go back, never the card data of the request.
+func (lrh *ListenerRequestHandler) respondOther(request *shared.Transaction) *shared.Transaction {
+	response := &shared.Transaction{
+		MTI: shared.ResponseMTI(request.MTI),
+		F7:  request.F7,
+		F11: request.F11,
+		F37: request.F37,
+		F39: network.ResponseApproved,
+		F41: request.F41,
+		F70: request.F70,
+	}
+	switch {
+	case strings.HasPrefix(request.MTI, mtiReversalAdvice):
+		// nothing reverses on behalf of the host yet, it keeps the advice until it is processed.
+		fmt.Printf("\nHandleHostRequest | reversal advice MTI %s STAN %s not processed", request.MTI, request.F11)
+		response.F39 = responseNotProcessed
+	case len(request.MTI) != 4 || request.MTI[2] != functionAdvice:
+		fmt.Printf("\nHandleHostRequest | unsupported MTI %s", request.MTI)
+		response.F39 = network.ResponseInvalid
+	}
+	return response
+}
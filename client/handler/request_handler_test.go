@@ -0,0 +1,109 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"megalink/gateway/client/network"
+	"megalink/gateway/shared"
+	"reflect"
+	"testing"
+)
+
+func TestHandleHostRequest(t *testing.T) {
+	tests := []struct {
+		name    string
+		request *shared.Transaction
+		want    *shared.Transaction
+		wantKey string
+		cutover bool
+	}{
+		{
+			name:    "echo",
+			request: &shared.Transaction{MTI: "0800", F7: "1017101010", F11: "000001", F41: "TERM0001", F70: network.CodeEcho},
+			want:    &shared.Transaction{MTI: "0810", F7: "1017101010", F11: "000001", F39: "00", F41: "TERM0001", F70: network.CodeEcho},
+		},
+		{
+			name:    "key change",
+			request: &shared.Transaction{MTI: "0800", F11: "000002", F48: "0123456789ABCDEF", F70: network.CodeKeyChange},
+			want:    &shared.Transaction{MTI: "0810", F11: "000002", F39: "00", F70: network.CodeKeyChange},
+			wantKey: "0123456789ABCDEF",
+		},
+		{
+			name:    "key change without key",
+			request: &shared.Transaction{MTI: "0800", F11: "000003", F70: network.CodeKeyChange},
+			want:    &shared.Transaction{MTI: "0810", F11: "000003", F39: network.ResponseInvalid, F70: network.CodeKeyChange},
+		},
+		{
+			name:    "cutover",
+			request: &shared.Transaction{MTI: "0800", F11: "000004", F70: network.CodeCutover},
+			want:    &shared.Transaction{MTI: "0810", F11: "000004", F39: "00", F70: network.CodeCutover},
+			cutover: true,
+		},
+		{
+			name:    "unsupported network code",
+			request: &shared.Transaction{MTI: "0800", F11: "000005", F70: "999"},
+			want:    &shared.Transaction{MTI: "0810", F11: "000005", F39: network.ResponseInvalid, F70: "999"},
+		},
+		{
+			name: "advice echoes no card data",
+			request: &shared.Transaction{
+				MTI: "0220", F2: "4111111111111111", F4: "000000000100", F11: "000006",
+				F35: "4111111111111111=2812", F37: "629015000006", F41: "TERM0001",
+			},
+			want: &shared.Transaction{MTI: "0230", F11: "000006", F37: "629015000006", F39: "00", F41: "TERM0001"},
+		},
+		{
+			name:    "reversal advice not approved",
+			request: &shared.Transaction{MTI: "0420", F2: "4111111111111111", F11: "000007"},
+			want:    &shared.Transaction{MTI: "0430", F11: "000007", F39: responseNotProcessed},
+		},
+		{
+			name:    "reversal advice repeat not approved",
+			request: &shared.Transaction{MTI: "0421", F11: "000008"},
+			want:    &shared.Transaction{MTI: "0430", F11: "000008", F39: responseNotProcessed},
+		},
+		{
+			name:    "authorisation request declined",
+			request: &shared.Transaction{MTI: "0100", F2: "4111111111111111", F11: "000009"},
+			want:    &shared.Transaction{MTI: "0110", F11: "000009", F39: network.ResponseInvalid},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sent *shared.Transaction
+			var key string
+			cutover := false
+			h := NewRequestHandler(
+				func(_ io.Writer, tx *shared.Transaction) error {
+					sent = tx
+					return nil
+				},
+				func(k string) { key = k },
+				func() { cutover = true },
+			)
+
+			if err := h.HandleHostRequest(nil, tt.request); err != nil {
+				t.Fatalf("HandleHostRequest: %v", err)
+			}
+			if !reflect.DeepEqual(sent, tt.want) {
+				t.Errorf("replied %+v, want %+v", sent, tt.want)
+			}
+			if key != tt.wantKey {
+				t.Errorf("working key %q, want %q", key, tt.wantKey)
+			}
+			if cutover != tt.cutover {
+				t.Errorf("cutover %v, want %v", cutover, tt.cutover)
+			}
+		})
+	}
+}
+
+func TestHandleHostRequestSendError(t *testing.T) {
+	errBroken := errors.New("broken pipe")
+	h := NewRequestHandler(func(io.Writer, *shared.Transaction) error { return errBroken }, nil, nil)
+	err := h.HandleHostRequest(nil, &shared.Transaction{MTI: "0800", F70: network.CodeEcho})
+	if !errors.Is(err, errBroken) {
+		t.Errorf("HandleHostRequest error = %v, want the send error", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"megalink/gateway/client/channels"
+	"megalink/gateway/client/correlation"
 	"megalink/gateway/shared"
 )
 
@@ -17,26 +18,42 @@ type (
 	ListenerResponseHandler struct {
 		// Ctx handles context.
 		Ctx     context.Context
-		Channel *channels.ChannelStruct[*shared.Transaction]
+		Pending *channels.Registry[*shared.Transaction]
+		// CorrelationKey identifies the channel waiting for a response.
+		CorrelationKey correlation.KeyFunc
+		// Orphan receives the responses nobody waits for, ignored if nil.
+		Orphan func(*shared.Transaction)
 	}
 )
 
 // NewResponseHandler provides an ResponseHandler.
-func NewResponseHandler(ctx context.Context, channel *channels.ChannelStruct[*shared.Transaction]) ResponseHandler {
+func NewResponseHandler(
+	ctx context.Context,
+	pending *channels.Registry[*shared.Transaction],
+	correlationKey correlation.KeyFunc,
+	orphan func(*shared.Transaction),
+) ResponseHandler {
 	return &ListenerResponseHandler{
-		Ctx:     ctx,
-		Channel: channel,
+		Ctx:            ctx,
+		Pending:        pending,
+		CorrelationKey: correlationKey,
+		Orphan:         orphan,
 	}
 }
 
 // HandleMessageResponse handles message response.
 func (lrh *ListenerResponseHandler) HandleMessageResponse(_ MessageHandlerFunc) MessageHandlerFunc {
 	return func(_ io.ReadWriter, response *shared.Transaction) error {
-		idCh := fmt.Sprintf("%s%s", response.F12, response.F13)
-		lrh.Channel.Set(channels.CHMessageFields[*shared.Transaction]{
-			Resp: response,
-			ID:   idCh,
-		})
+		id := lrh.CorrelationKey(response)
+		if lrh.Pending.Resolve(id, response) {
+			return nil
+		}
+
+		if lrh.Orphan == nil {
+			fmt.Printf("\nHandleMessageResponse | nobody waits for MTI %s %s", response.MTI, id)
+			return nil
+		}
+		lrh.Orphan(response)
 
 		return nil
 	}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"megalink/gateway/client/metrics"
+	"megalink/gateway/shared"
+)
+
+const (
+	// mtiWildcard matches any digit in a route pattern.
+	mtiWildcard = 'x'
+	// metricUnknownMTI counter of messages without route, suffixed by their MTI.
+	metricUnknownMTI = "listener.unknown_mti."
+)
+
+var (
+	// ErrInvalidPattern triggered when registering a route whose MTI pattern is not 4 digits or x.
+	ErrInvalidPattern = errors.New("invalid MTI pattern")
+)
+
+type (
+	// Router dispatches messages to the handler registered for their MTI.
+	Router struct {
+		routes []route
+		// Default handles messages without route.
+		Default MessageHandlerFunc
+		Metrics metrics.IMetrics
+	}
+
+	route struct {
+		pattern string
+		handler MessageHandlerFunc
+	}
+)
+
+// NewRouter provides a Router whose default handler logs and counts unknown MTIs.
+func NewRouter(m metrics.IMetrics) *Router {
+	r := &Router{Metrics: m}
+	r.Default = r.unknown
+	return r
+}
+
+// Handle registers h for the MTIs matching pattern, 4 digits where x matches any digit, e.g. 08xx, 04x0.
+// The most specific pattern wins, ties go to the first registered.
+func (r *Router) Handle(pattern string, h MessageHandlerFunc) error {
+	if len(pattern) != 4 {
+		return fmt.Errorf("%w %q", ErrInvalidPattern, pattern)
+	}
+	for i := range pattern {
+		if pattern[i] != mtiWildcard && (pattern[i] < '0' || pattern[i] > '9') {
+			return fmt.Errorf("%w %q", ErrInvalidPattern, pattern)
+		}
+	}
+
+	// keep routes sorted by specificity so the first match is the best one.
+	at := len(r.routes)
+	for i, existing := range r.routes {
+		if wildcards(pattern) < wildcards(existing.pattern) {
+			at = i
+			break
+		}
+	}
+	r.routes = append(r.routes, route{})
+	copy(r.routes[at+1:], r.routes[at:])
+	r.routes[at] = route{pattern: pattern, handler: h}
+	return nil
+}
+
+// Route is the ListenerHandlerFunc of the router, it ends the chain so next is not called.
+func (r *Router) Route(_ MessageHandlerFunc) MessageHandlerFunc {
+	return func(writer io.ReadWriter, message *shared.Transaction) error {
+		for _, rt := range r.routes {
+			if matchMTI(rt.pattern, message.MTI) {
+				return rt.handler(writer, message)
+			}
+		}
+		return r.Default(writer, message)
+	}
+}
+
+// unknown logs and counts a message without route.
+func (r *Router) unknown(_ io.ReadWriter, message *shared.Transaction) error {
+	fmt.Printf("\nRouter | no route for MTI %q STAN %s", message.MTI, message.F11)
+	if r.Metrics != nil {
+		r.Metrics.Inc(metricUnknownMTI + message.MTI)
+	}
+	return nil
+}
+
+func matchMTI(pattern, mti string) bool {
+	if len(mti) != len(pattern) {
+		return false
+	}
+	for i := range pattern {
+		if pattern[i] != mtiWildcard && pattern[i] != mti[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func wildcards(pattern string) int {
+	n := 0
+	for i := range pattern {
+		if pattern[i] == mtiWildcard {
+			n++
+		}
+	}
+	return n
+}
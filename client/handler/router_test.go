@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"errors"
+	"io"
+	"megalink/gateway/client/metrics"
+	"megalink/gateway/shared"
+	"testing"
+)
+
+func TestRouterPicksMostSpecificPattern(t *testing.T) {
+	m := metrics.NewMetrics()
+	router := NewRouter(m)
+
+	var got string
+	register := func(pattern string) {
+		if err := router.Handle(pattern, func(io.ReadWriter, *shared.Transaction) error {
+			got = pattern
+			return nil
+		}); err != nil {
+			t.Fatalf("Handle(%q): %v", pattern, err)
+		}
+	}
+	register("0xxx")
+	register("0x10")
+	register("0210")
+	register("08xx")
+
+	tests := []struct {
+		mti  string
+		want string
+	}{
+		{mti: "0210", want: "0210"},
+		{mti: "0110", want: "0x10"},
+		{mti: "0810", want: "0x10"},
+		{mti: "0800", want: "08xx"},
+		{mti: "0420", want: "0xxx"},
+		{mti: "1210", want: ""},
+		{mti: "021", want: ""},
+	}
+
+	route := router.Route(nil)
+	for _, tt := range tests {
+		t.Run(tt.mti, func(t *testing.T) {
+			got = ""
+			if err := route(nil, &shared.Transaction{MTI: tt.mti}); err != nil {
+				t.Fatalf("Route: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("routed to %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	if unknown := m.Get(metricUnknownMTI + "1210"); unknown != 1 {
+		t.Errorf("unknown MTI 1210 counted %d times, want 1", unknown)
+	}
+}
+
+func TestRouterTiesGoToFirstRegistered(t *testing.T) {
+	router := NewRouter(nil)
+	var got string
+	for _, name := range []string{"first", "second"} {
+		name := name
+		if err := router.Handle("02x0", func(io.ReadWriter, *shared.Transaction) error {
+			got = name
+			return nil
+		}); err != nil {
+			t.Fatalf("Handle: %v", err)
+		}
+	}
+
+	if err := router.Route(nil)(nil, &shared.Transaction{MTI: "0200"}); err != nil {
+		t.Fatalf("Route: %v", err)
+	}
+	if got != "first" {
+		t.Errorf("routed to %q, want first", got)
+	}
+}
+
+func TestRouterRejectsInvalidPatterns(t *testing.T) {
+	router := NewRouter(nil)
+	for _, pattern := range []string{"", "021", "02100", "02X0", "02a0", "0*10"} {
+		if err := router.Handle(pattern, nil); !errors.Is(err, ErrInvalidPattern) {
+			t.Errorf("Handle(%q) error = %v, want ErrInvalidPattern", pattern, err)
+		}
+	}
+}
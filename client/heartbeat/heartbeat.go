@@ -2,14 +2,14 @@ package heartbeat
 
 import (
 	"context"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"megalink/gateway/client/handler"
+	"megalink/gateway/client/network"
+	"megalink/gateway/client/sender"
 	"megalink/gateway/client/types"
-	"megalink/gateway/client/utils"
 	"megalink/gateway/logger"
 	"megalink/gateway/shared"
 	"sync/atomic"
@@ -20,8 +20,7 @@ const (
 	// Maximum heartbeat retries before performing a sign on.
 	maxHeartbeatRetries = 3
 	// DE39 echo test successfully response code.
-	deEchoSuccessfully = "00"
-	messageTypeEcho    = "ECHO"
+	deEchoSuccessfully = network.ResponseApproved
 )
 
 var (
@@ -45,10 +44,16 @@ type HeartBeatService struct {
 	EnvVars          *types.EnvVars
 	WaitResponseTime time.Duration
 	Logger           logger.IFastLogger
+	// Send writes echo requests through the sender chain.
+	Send sender.SendFunc
 }
 
 // NewHeartBeatService provides a new HeartBeatService with default config.
-func NewHeartBeatService(envVars *types.EnvVars, logger logger.IFastLogger) IHeartbeatService {
+func NewHeartBeatService(
+	envVars *types.EnvVars,
+	logger logger.IFastLogger,
+	send sender.SendFunc,
+) IHeartbeatService {
 	return &HeartBeatService{
 		EchoTestResponse: make(chan *shared.Transaction),
 		EchoRetries:      0,
@@ -56,6 +61,7 @@ func NewHeartBeatService(envVars *types.EnvVars, logger logger.IFastLogger) IHea
 		EnvVars:          envVars,
 		WaitResponseTime: time.Duration(envVars.HeartBeatResponseWaitSeconds) * time.Second,
 		Logger:           logger,
+		Send:             send,
 	}
 }
 
@@ -65,23 +71,13 @@ func (hb *HeartBeatService) SendEchoTest(writer io.ReadWriter) {
 		fmt.Printf("\nSendEchoTest ======= echo retries %d", atomic.LoadUint64(&hb.EchoRetries))
 	}
 
-	request := &shared.Transaction{
-		MTI: messageTypeEcho,
-		F12: utils.GetTimeField("UTC"),
-		F13: utils.GetTimeField("UTC"),
-	}
-	// Encode heartbeat request to JSON
-	requestBytes, err := json.Marshal(request)
-	if err != nil {
-		fmt.Printf("\nSendEchoTest | Marshall err %v", err)
+	request := network.NewRequest(network.CodeEcho)
+	if err := hb.Send(writer, request); err != nil && hb.EnvVars.ShowEcho {
+		fmt.Printf("\nSendEchoTest | Send err %v ", err)
 	}
 
 	if hb.EnvVars.ShowEcho {
-		fmt.Printf("\nSendEchoTest ======= sending %s", string(requestBytes))
-	}
-
-	if _, err = writer.Write(requestBytes); err != nil && hb.EnvVars.ShowEcho {
-		fmt.Printf("\nSendEchoTest | Write err %v ", err)
+		fmt.Printf("\nSendEchoTest ======= sent STAN %s", request.F11)
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), hb.WaitResponseTime)
@@ -139,8 +135,8 @@ func (hb *HeartBeatService) checkEchoResponse(res *shared.Transaction) {
 // HandleHeartBeatResponse handles echo test response from Datafast.
 func (hb *HeartBeatService) HandleHeartBeatResponse(next handler.MessageHandlerFunc) handler.MessageHandlerFunc {
 	return func(conn io.ReadWriter, response *shared.Transaction) error {
-		// if is not type echo send to next handler
-		if response.MTI != messageTypeEcho {
+		// if is not an echo response send to next handler
+		if response.F70 != network.CodeEcho {
 			return next(conn, response)
 		}
 
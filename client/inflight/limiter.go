@@ -0,0 +1,107 @@
+// Package inflight caps the transactions outstanding on the franchise link.
+package inflight
+
+import (
+	"context"
+	"errors"
+	"megalink/gateway/client/metrics"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// metricInFlight gauge of transactions waiting for the franchise, suffixed by its name.
+	metricInFlight = "inflight.current."
+	// metricQueued gauge of transactions waiting for a slot, suffixed by the franchise name.
+	metricQueued = "inflight.queued."
+	// metricRejected counter of transactions rejected because the window was full, suffixed by the franchise name.
+	metricRejected = "inflight.rejected."
+)
+
+var (
+	// ErrSaturated triggered when no slot frees up within the queue timeout or the queue is full.
+	ErrSaturated = errors.New("max in-flight transactions reached")
+)
+
+type (
+	// ILimiter grants slots of the franchise window.
+	ILimiter interface {
+		// Acquire waits for a free slot, at most the queue timeout.
+		Acquire(ctx context.Context) error
+		// Release gives back a slot got with Acquire.
+		Release()
+	}
+
+	// Limiter implements ILimiter with a semaphore.
+	Limiter struct {
+		// Name of the franchise, used in metric names.
+		Name string
+		// QueueSize transactions allowed to wait for a slot, unlimited if 0.
+		QueueSize int64
+		// QueueTimeout longest wait for a slot.
+		QueueTimeout time.Duration
+		Metrics      metrics.IMetrics
+		slots        chan struct{}
+		queued       int64
+	}
+)
+
+// NewLimiter provides a Limiter of maxInFlight slots, unlimited if 0.
+func NewLimiter(name string, maxInFlight int, queueSize int, queueTimeout time.Duration, m metrics.IMetrics) ILimiter {
+	l := &Limiter{
+		Name:         name,
+		QueueSize:    int64(queueSize),
+		QueueTimeout: queueTimeout,
+		Metrics:      m,
+	}
+	if maxInFlight > 0 {
+		l.slots = make(chan struct{}, maxInFlight)
+	}
+	return l
+}
+
+// Acquire takes a free slot or waits for one in queue, at most the queue timeout.
+func (l *Limiter) Acquire(ctx context.Context) error {
+	if l.slots == nil {
+		l.Metrics.Add(metricInFlight+l.Name, 1)
+		return nil
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		l.Metrics.Add(metricInFlight+l.Name, 1)
+		return nil
+	default:
+	}
+
+	queued := atomic.AddInt64(&l.queued, 1)
+	defer func() {
+		l.Metrics.Set(metricQueued+l.Name, atomic.AddInt64(&l.queued, -1))
+	}()
+	if l.QueueSize > 0 && queued > l.QueueSize {
+		l.Metrics.Inc(metricRejected + l.Name)
+		return ErrSaturated
+	}
+	l.Metrics.Set(metricQueued+l.Name, queued)
+
+	timer := time.NewTimer(l.QueueTimeout)
+	defer timer.Stop()
+	select {
+	case l.slots <- struct{}{}:
+		l.Metrics.Add(metricInFlight+l.Name, 1)
+		return nil
+	case <-timer.C:
+		l.Metrics.Inc(metricRejected + l.Name)
+		return ErrSaturated
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release gives back a slot got with Acquire.
+func (l *Limiter) Release() {
+	l.Metrics.Add(metricInFlight+l.Name, -1)
+	if l.slots != nil {
+		<-l.slots
+	}
+}
@@ -0,0 +1,92 @@
+package inflight
+
+import (
+	"context"
+	"errors"
+	"megalink/gateway/client/metrics"
+	"testing"
+	"time"
+)
+
+func TestLimiterWindow(t *testing.T) {
+	m := metrics.NewMetrics()
+	l := NewLimiter("visa", 2, 0, 10*time.Millisecond, m)
+
+	for i := 0; i < 2; i++ {
+		if err := l.Acquire(context.Background()); err != nil {
+			t.Fatalf("Acquire #%d: %v", i, err)
+		}
+	}
+	if got := m.Get(metricInFlight + "visa"); got != 2 {
+		t.Errorf("in flight = %d, want 2", got)
+	}
+
+	if err := l.Acquire(context.Background()); !errors.Is(err, ErrSaturated) {
+		t.Errorf("Acquire on a full window error = %v, want ErrSaturated", err)
+	}
+	if got := m.Get(metricRejected + "visa"); got != 1 {
+		t.Errorf("rejected = %d, want 1", got)
+	}
+
+	l.Release()
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Errorf("Acquire after Release: %v", err)
+	}
+}
+
+func TestLimiterQueuedGetsReleasedSlot(t *testing.T) {
+	m := metrics.NewMetrics()
+	l := NewLimiter("visa", 1, 1, time.Second, m)
+	if err := l.Acquire(context.Background()); err != nil {
+		t.Fatalf("Acquire: %v", err)
+	}
+
+	acquired := make(chan error, 1)
+	go func() { acquired <- l.Acquire(context.Background()) }()
+
+	// wait for the second transaction to queue, a third finds the queue full.
+	deadline := time.Now().Add(time.Second)
+	for m.Get(metricQueued+"visa") == 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if err := l.Acquire(context.Background()); !errors.Is(err, ErrSaturated) {
+		t.Errorf("Acquire on a full queue error = %v, want ErrSaturated", err)
+	}
+
+	l.Release()
+	select {
+	case err := <-acquired:
+		if err != nil {
+			t.Errorf("queued Acquire: %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("queued Acquire did not get the released slot")
+	}
+}
+
+func TestLimiterCancelledWhileQueued(t *testing.T) {
+	l := NewLimiter("visa", 1, 0, time.Second, metrics.NewMetrics())
+	_ = l.Acquire(context.Background())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := l.Acquire(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("Acquire error = %v, want Canceled", err)
+	}
+}
+
+func TestLimiterUnlimited(t *testing.T) {
+	m := metrics.NewMetrics()
+	l := NewLimiter("visa", 0, 0, 0, m)
+	for i := 0; i < 100; i++ {
+		if err := l.Acquire(context.Background()); err != nil {
+			t.Fatalf("Acquire #%d: %v", i, err)
+		}
+	}
+	for i := 0; i < 100; i++ {
+		l.Release()
+	}
+	if got := m.Get(metricInFlight + "visa"); got != 0 {
+		t.Errorf("in flight = %d, want 0", got)
+	}
+}
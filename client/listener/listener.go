@@ -3,13 +3,13 @@ package listener
 import (
 	"bytes"
 	"context"
-	"encoding/binary"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"megalink/gateway/client/handler"
 	"megalink/gateway/client/types"
-	"megalink/gateway/shared"
+	"megalink/gateway/shared/codec"
+	"megalink/gateway/shared/framing"
 	"time"
 )
 
@@ -35,6 +35,10 @@ type Listener struct {
 	ReadTimeout time.Duration
 	// ErrHandler handles critical errors.
 	ErrHandler handler.ErrorHandler
+	// Codec decodes messages read from connection.
+	Codec codec.Codec
+	// Framer delimits messages read from connection.
+	Framer framing.Framer
 	// GtwDynamoConfig handles dynamoConfigGtw.
 	EnvVars *types.EnvVars
 }
@@ -44,6 +48,8 @@ func NewListener(
 	conn io.ReadWriter,
 	handler handler.MessageHandlerFunc,
 	errorHandler handler.ErrorHandler,
+	messageCodec codec.Codec,
+	framer framing.Framer,
 	envVars *types.EnvVars) *Listener {
 	return &Listener{
 		Conn:        conn,
@@ -51,79 +57,98 @@ func NewListener(
 		ReadBuffer:  readBufferSize,
 		ReadTimeout: readTimeout,
 		ErrHandler:  errorHandler,
+		Codec:       messageCodec,
+		Framer:      framer,
 		EnvVars:     envVars,
 	}
 }
 
-// Listen listens to a connection for new arriving bytes with a timeout.
-func (ls *Listener) Listen(ctx context.Context) {
+// Listen reads messages from the connection until ctx is done or the connection fails.
+// It returns nil when ctx is done, io.EOF when the franchise hangs up and the read error otherwise,
+// the connection cannot be read anymore in both cases.
+func (ls *Listener) Listen(ctx context.Context) error {
 	for {
-		select {
-		case <-ctx.Done():
+		if ctx.Err() != nil {
 			fmt.Println("\nListener | Listen", "Shutting down listener")
-			return
-		default:
-			readCtx, cancel := context.WithTimeout(ctx, ls.ReadTimeout)
-			defer cancel()
-
-			header := make([]byte, 4) // Assume the message length is encoded in the first 4 bytes
-			if _, err := io.ReadFull(ls.Conn, header); err != nil {
-				fmt.Println("\nListener | Failed to read message header:", err)
-				//they have sleep here
-				time.Sleep(time.Second)
-				continue
-			}
+			return nil
+		}
 
-			messageLength := binary.BigEndian.Uint32(header)
+		err := ls.readMessage(ctx)
+		switch {
+		case err == nil:
+		case ctx.Err() != nil:
+			// the connection was closed on purpose.
+			fmt.Println("\nListener | Listen", "Shutting down listener")
+			return nil
+		case errors.Is(err, io.EOF):
+			fmt.Println("\nListener | Connection closed by franchise")
+			return io.EOF
+		default:
+			fmt.Println("\nListener | Listen", err)
+			return err
+		}
+	}
+}
 
-			bytesToRead := int(messageLength)
-			tmpData := make([]byte, readBufferSize)
+// readMessage reads a whole message and hands it to the handler.
+// Only read errors are returned, a message which cannot be decoded or handled is logged.
+func (ls *Listener) readMessage(ctx context.Context) error {
+	messageLength, err := ls.Framer.ReadHeader(ls.Conn)
+	if err != nil {
+		return fmt.Errorf("failed to read message header: %w", err)
+	}
 
-			bufferData := bytes.NewBuffer(make([]byte, 0, messageLength))
+	readCtx, cancel := context.WithTimeout(ctx, ls.ReadTimeout)
+	defer cancel()
 
-			done := make(chan error, 1)
+	bufferData := bytes.NewBuffer(make([]byte, 0, messageLength))
+	done := make(chan error, 1)
+	go func() {
+		tmpData := make([]byte, ls.ReadBuffer)
+		for bytesToRead := messageLength; bytesToRead > 0; {
+			chunk := bytesToRead
+			if chunk > len(tmpData) {
+				chunk = len(tmpData)
+			}
+			nBytes, err := io.ReadFull(ls.Conn, tmpData[:chunk])
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				err = io.EOF
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+			bytesToRead -= nBytes
+			_, _ = bufferData.Write(tmpData[:nBytes])
+		}
+		done <- nil
+	}()
 
-			go func() {
-			readLoop:
-				for bytesToRead > 0 {
-					select {
-					case <-readCtx.Done():
-						done <- readCtx.Err()
-						break readLoop
-					default:
-						nBytes, err := io.ReadFull(ls.Conn, tmpData[:bytesToRead])
-						if err == io.EOF || err == io.ErrUnexpectedEOF {
-							continue
-						}
-						if err != nil {
-							done <- err
-							break readLoop
-						}
-						bytesToRead -= nBytes
-						_, _ = bufferData.Write(tmpData[:nBytes])
-					}
-				}
+	select {
+	case <-readCtx.Done():
+		// the rest of the message may still come, the stream cannot be trusted anymore.
+		return fmt.Errorf("read timeout: %w", readCtx.Err())
+	case err := <-done:
+		if err != nil {
+			return fmt.Errorf("failed to read message: %w", err)
+		}
+	}
 
-				// Decode server response from JSON
-				var serverResponse shared.Transaction
-				if err := json.Unmarshal(bufferData.Bytes(), &serverResponse); err != nil {
-					done <- fmt.Errorf("failed to unmarshal server response: %w", err)
-					return
-				}
+	payload, err := ls.Framer.Unframe(bufferData.Bytes())
+	if err != nil {
+		fmt.Println("Listener | Handler error:", fmt.Errorf("failed to unframe server response: %w", err))
+		return nil
+	}
 
-				done <- ls.Handler(ls.Conn, &serverResponse)
-			}()
+	// Decode server response
+	serverResponse, err := ls.Codec.Decode(payload)
+	if err != nil {
+		fmt.Println("Listener | Handler error:", fmt.Errorf("failed to unmarshal server response: %w", err))
+		return nil
+	}
 
-			select {
-			case <-readCtx.Done():
-				fmt.Println("Listener | Read timeout")
-				continue
-			case err := <-done:
-				if err != nil {
-					fmt.Println("Listener | Handler error:", err)
-					continue
-				}
-			}
-		}
+	if err := ls.Handler(ls.Conn, serverResponse); err != nil {
+		fmt.Println("Listener | Handler error:", err)
 	}
+	return nil
 }
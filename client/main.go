@@ -4,16 +4,26 @@ import (
 	"context"
 	"fmt"
 	"log"
+	"megalink/gateway/client/breaker"
 	"megalink/gateway/client/channels"
 	"megalink/gateway/client/connection"
+	"megalink/gateway/client/correlation"
 	"megalink/gateway/client/handler"
-	heartbeatService "megalink/gateway/client/heartbeat"
-	"megalink/gateway/client/listener"
+	"megalink/gateway/client/inflight"
+	"megalink/gateway/client/metrics"
+	"megalink/gateway/client/orphan"
+	"megalink/gateway/client/reversal"
+	"megalink/gateway/client/routing"
+	"megalink/gateway/client/saf"
+	"megalink/gateway/client/sender"
+	"megalink/gateway/client/sequence"
 	"megalink/gateway/client/service"
-	"megalink/gateway/client/sign"
+	"megalink/gateway/client/stip"
 	"megalink/gateway/client/types"
 	"megalink/gateway/logger"
 	"megalink/gateway/shared"
+	"megalink/gateway/shared/codec"
+	"megalink/gateway/shared/framing"
 	"net/http"
 	"os"
 	"os/signal"
@@ -32,7 +42,7 @@ func main() {
 			fmt.Println("stacktrace from panic: \n" + string(debug.Stack()))
 		}
 	}()
-	channel := channels.ProvideChannels[*shared.Transaction]()
+	pending := channels.NewRegistry[*shared.Transaction]()
 
 	ctx := context.Background()
 
@@ -47,49 +57,172 @@ func main() {
 	// Define server address for health check and heartbeat
 	envVars := types.EnvVars{
 		GinServerAdress:              "localhost:8080",
-		FranchiseConnectionAdress:    "localhost:9090",
 		ShowEcho:                     false,
 		HeartSendBeatIntervalSeconds: 30,
 		HeartBeatResponseWaitSeconds: 30,
+		ResponseWaitSeconds:          20,
+		SignOnResponseWaitSeconds:    10,
+		KeyChangeIntervalMinutes:     720,
+		ShutdownWaitSeconds:          30,
+		MessageCodec:                 codec.ISO8583ASCII,
+		FieldSpecPath:                "specs/iso8583-ascii.yaml",
+		Framing:                      framing.Binary4,
+		TerminalID:                   "TERM0001",
+		MerchantID:                   "MERCHANT000001",
+		AcquirerID:                   "123456",
+		CorrelationFields:            correlation.DefaultFields,
+		SequenceStorePath:            "sequences.json",
+		SequenceCutoverHour:          0,
+		SequenceReserveBlock:         100,
+		SAFMaxRetries:                5,
+		SAFResponseWaitSeconds:       20,
+		MaxInFlight:                  64,
+		InFlightQueueSize:            128,
+		InFlightQueueTimeoutSeconds:  5,
+		InFlightRetryAfterSeconds:    1,
+		OrphanJournalPath:            "orphans.jsonl",
+		LateResponseRetentionSeconds: 600,
+		PrimaryProbeIntervalSeconds:  10,
+		FailbackHealthyProbes:        3,
+		FailbackDrainSeconds:         20,
+		RoutingTablePath:             "specs/routing.yaml",
+		Franchises: []types.Franchise{
+			{
+				Name:         "visa",
+				Addresses:    []string{"localhost:9090", "localhost:9091"},
+				Connections:  2,
+				Selection:    connection.SelectLeastInFlight,
+				SAFStorePath: "saf-visa.json",
+			},
+			{Name: "mastercard", Addresses: []string{"localhost:9090", "localhost:9091"}, SAFStorePath: "saf-mastercard.json"},
+			{Name: "local", Addresses: []string{"localhost:9090", "localhost:9091"}, SAFStorePath: "saf-local.json"},
+		},
+		ReconnectBackoffInitialSeconds: 1,
+		ReconnectBackoffMaxSeconds:     60,
+		BreakerFailureThreshold:        5,
+		BreakerOpenSeconds:             30,
+		BreakerHalfOpenProbes:          1,
+		BreakerFailureCodes:            []string{"91", "96"},
+		StandInMaxAmount:               5000,
+		StandInCardLimit:               10000,
+		StandInMerchantLimit:           100000,
 	}
+	// secrets never live in the source.
+	envVars.SAFEncryptionKey = os.Getenv("SAF_ENCRYPTION_KEY")
 
-	signService := sign.NewSignService(&envVars)
-	connFact := connection.NewConnFactory(&envVars)
-	heartbeat := heartbeatService.NewHeartBeatService(&envVars, myLogger)
-	connManager := connection.NewConnManager(signService, heartbeat, connFact, &envVars)
-	errHandler := handler.NewErrorHandler()
-	respHandler := handler.NewResponseHandler(ctx, channel)
+	sequenceStore, err := sequence.NewStore(envVars.SequenceStorePath, envVars.SequenceCutoverHour, envVars.SequenceReserveBlock)
+	if err != nil {
+		log.Fatal(err)
+	}
+	stanGenerator := sequence.NewSTANGenerator(sequenceStore)
+
+	gatewayMetrics := metrics.NewMetrics()
 
-	dataFastHandler := new(listener.ListenerChain).
-		AddHandler(errHandler.HandleMessageError).
-		AddHandler(heartbeat.HandleHeartBeatResponse).
-		AddHandler(respHandler.HandleMessageResponse).
-		BuildChain()
+	routes, err := routing.LoadTable(envVars.RoutingTablePath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	errHandler := handler.NewErrorHandler()
+	correlationKey := correlation.NewKeyFunc(envVars.CorrelationFields)
 
-	// Listen for response.
-	listenerService := listener.NewListener(connManager, dataFastHandler, errHandler, &envVars)
-	_ = connManager.SetupConnection(ctx)
+	// filled with the queue of every franchise below.
+	safQueues := make(map[string]saf.IQueue)
+	reversalService := reversal.NewReversalService(safQueues, routes, stanGenerator, &envVars, myLogger)
+	orphanHandler := orphan.NewOrphanHandler(
+		envVars.OrphanJournalPath,
+		time.Duration(envVars.LateResponseRetentionSeconds)*time.Second,
+		correlationKey,
+		reversalService,
+		gatewayMetrics,
+		myLogger,
+	)
+	respHandler := handler.NewResponseHandler(ctx, pending, correlationKey, orphanHandler.Handle)
 
-	// ctx must be a context.Background() to listen forever.
-	go listenerService.Listen(ctx)
+	deps := &franchiseDeps{
+		EnvVars:        &envVars,
+		Logger:         myLogger,
+		Metrics:        gatewayMetrics,
+		STAN:           stanGenerator,
+		Pending:        pending,
+		CorrelationKey: correlationKey,
+		RespHandler:    respHandler,
+		ErrHandler:     errHandler,
+	}
+	connections := connection.NewRegistry()
+	breakers := make(map[string]breaker.IBreaker)
+	senders := make(map[string]sender.SendFunc)
+	limiters := make(map[string]inflight.ILimiter)
+	for _, franchise := range envVars.Franchises {
+		fs, err := setupFranchise(ctx, deps, franchise)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := connections.Add(franchise.Name, fs.Pool); err != nil {
+			log.Fatal(err)
+		}
+		safQueues[franchise.Name] = fs.Queue
+		senders[franchise.Name] = fs.Send
+		limiters[franchise.Name] = fs.Limiter
+		breakers[franchise.Name] = breaker.NewBreaker(
+			franchise.Name,
+			envVars.BreakerFailureThreshold,
+			time.Duration(envVars.BreakerOpenSeconds)*time.Second,
+			envVars.BreakerHalfOpenProbes,
+			gatewayMetrics,
+		)
+	}
+	for _, name := range routes.Franchises() {
+		if _, err := connections.Get(name); err != nil {
+			log.Fatal("Routing table: ", err)
+		}
+	}
 
 	// Create a Gin router
 	router := gin.New()
 
 	router.Use(LoggingMiddleware(myLogger))
-	router.Use(CustomRecoveryMiddleware(channel))
+	router.Use(CustomRecoveryMiddleware())
+
+	// low value transactions are approved locally while their franchise is unavailable.
+	var standIn stip.IStandIn
+	if envVars.StandInMaxAmount > 0 {
+		standIn = stip.NewStandIn(
+			envVars.StandInMaxAmount,
+			envVars.StandInCardLimit,
+			envVars.StandInMerchantLimit,
+			envVars.SequenceCutoverHour,
+			safQueues,
+			routes,
+			gatewayMetrics,
+			myLogger,
+		)
+	}
 
 	sv := service.Service{
-		Connection: connManager,
-		Logger:     myLogger,
-		Channel:    channel,
+		Connections:    connections,
+		Routes:         routes,
+		Logger:         myLogger,
+		Pending:        pending,
+		Senders:        senders,
+		EnvVars:        &envVars,
+		STAN:           stanGenerator,
+		CorrelationKey: correlationKey,
+		Orphans:        orphanHandler,
+		Breakers:       breakers,
+		StandIn:        standIn,
+		Limiters:       limiters,
 	}
 	// Health check endpoint
-	router.GET("/healthcheck", func(c *gin.Context) {
-		c.JSON(http.StatusOK, gin.H{"message": "healthy"})
-	})
+	router.GET("/healthcheck", Healthcheck(connections, breakers))
 	router.POST("/transaction", sv.TransactionService)
 
+	admin := service.AdminService{Queues: safQueues}
+	router.GET("/admin/saf", admin.SAFItems)
+	router.GET("/metrics", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gatewayMetrics.Snapshot())
+	})
+
 	srv := &http.Server{
 		Addr:    envVars.GinServerAdress,
 		Handler: router.Handler(),
@@ -102,8 +235,8 @@ func main() {
 		}
 	}()
 
-	// Wait for interrupt signal to gracefully shutdown the server with
-	// a timeout of 5 seconds.
+	// Wait for interrupt signal to gracefully shutdown the server, signing off
+	// from the franchise within ShutdownWaitSeconds.
 	quit := make(chan os.Signal, 1)
 	// kill (no param) default send syscall.SIGTERM
 	// kill -2 is syscall.SIGINT
@@ -112,28 +245,59 @@ func main() {
 	<-quit
 	log.Println("Shutdown Server ...")
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(envVars.ShutdownWaitSeconds)*time.Second)
 	defer cancel()
+
+	// new transactions get a 503 while in-flight ones are answered or reversed.
+	if err := sv.Drain(ctx); err != nil {
+		log.Println("Draining transactions:", err)
+	}
 	if err := srv.Shutdown(ctx); err != nil {
-		log.Fatal("Server Shutdown:", err)
+		log.Println("Server Shutdown:", err)
 	}
-
-	select {
-	case <-ctx.Done():
-		channel.CloseChannels()
-		log.Println("timeout of 5 seconds.")
+	for _, name := range connections.Names() {
+		pool, _ := connections.Get(name)
+		if err := pool.Shutdown(); err != nil {
+			log.Println("Connection Shutdown:", name, err)
+		}
 	}
+	pending.CancelAll()
 	log.Println("Server exiting")
 }
 
-func CustomRecoveryMiddleware(channel *channels.ChannelStruct[*shared.Transaction]) gin.HandlerFunc {
+// Healthcheck reports the link and breaker of every franchise.
+// It is healthy while every franchise takes transactions, and unhealthy when none does.
+func Healthcheck(connections *connection.Registry, breakers map[string]breaker.IBreaker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		franchises := make(map[string]gin.H)
+		up := 0
+		for _, name := range connections.Names() {
+			pool, _ := connections.Get(name)
+			state, circuit := pool.State(), breakers[name].State()
+			franchises[name] = gin.H{"connection": state, "breaker": circuit}
+			if state == connection.StateReady && circuit != breaker.StateOpen {
+				up++
+			}
+		}
+
+		switch up {
+		case len(franchises):
+			c.JSON(http.StatusOK, gin.H{"message": "healthy", "franchises": franchises})
+		case 0:
+			c.JSON(http.StatusServiceUnavailable, gin.H{"message": "unhealthy", "franchises": franchises})
+		default:
+			c.JSON(http.StatusOK, gin.H{"message": "degraded", "franchises": franchises})
+		}
+	}
+}
+
+func CustomRecoveryMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		defer func() {
 			if r := recover(); r != nil {
 				// Log the panic with stack trace
 				log.Printf("Panic recovered: %s\n", r)
 				log.Printf("Stack trace: %s\n", debug.Stack())
-				channel.CloseChannels()
 				// Return a custom error response with the external message
 				c.JSON(http.StatusInternalServerError, gin.H{
 					"message": "Internal Server Error",
@@ -0,0 +1,71 @@
+// Package metrics keeps in memory counters and gauges of the gateway.
+package metrics
+
+import (
+	"sync"
+)
+
+type (
+	// IMetrics records named counters and gauges.
+	IMetrics interface {
+		// Inc adds one to counter name.
+		Inc(name string)
+		// Add adds delta to name, negative deltas are allowed for gauges.
+		Add(name string, delta int64)
+		// Set sets gauge name to value.
+		Set(name string, value int64)
+		// Get gets the current value of name, 0 if never recorded.
+		Get(name string) int64
+		// Snapshot gets a copy of every value.
+		Snapshot() map[string]int64
+	}
+
+	// Metrics implements IMetrics in memory.
+	Metrics struct {
+		mtx    sync.RWMutex
+		values map[string]int64
+	}
+)
+
+// NewMetrics provides an empty Metrics.
+func NewMetrics() IMetrics {
+	return &Metrics{values: make(map[string]int64)}
+}
+
+// Inc adds one to counter name.
+func (m *Metrics) Inc(name string) {
+	m.Add(name, 1)
+}
+
+// Add adds delta to name.
+func (m *Metrics) Add(name string, delta int64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.values[name] += delta
+}
+
+// Set sets gauge name to value.
+func (m *Metrics) Set(name string, value int64) {
+	m.mtx.Lock()
+	defer m.mtx.Unlock()
+	m.values[name] = value
+}
+
+// Get gets the current value of name, 0 if never recorded.
+func (m *Metrics) Get(name string) int64 {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+	return m.values[name]
+}
+
+// Snapshot gets a copy of every value.
+func (m *Metrics) Snapshot() map[string]int64 {
+	m.mtx.RLock()
+	defer m.mtx.RUnlock()
+
+	snapshot := make(map[string]int64, len(m.values))
+	for name, value := range m.values {
+		snapshot[name] = value
+	}
+	return snapshot
+}
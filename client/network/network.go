@@ -0,0 +1,70 @@
+// Package network builds the 0800 network management messages exchanged with the franchise.
+package network
+
+import (
+	"errors"
+	"fmt"
+	"megalink/gateway/shared"
+)
+
+const (
+	// MTIRequest network management request.
+	MTIRequest = "0800"
+	// MTIResponse network management response.
+	MTIResponse = "0810"
+
+	// CodeSignOn F70 sign on.
+	CodeSignOn = "001"
+	// CodeSignOff F70 sign off.
+	CodeSignOff = "002"
+	// CodeKeyChange F70 working key change.
+	CodeKeyChange = "161"
+	// CodeCutover F70 business day cutover.
+	CodeCutover = "201"
+	// CodeEcho F70 echo test.
+	CodeEcho = "301"
+
+	// ResponseApproved F39 of a successful network management response.
+	ResponseApproved = "00"
+	// ResponseInvalid F39 of a request the gateway does not support.
+	ResponseInvalid = "12"
+)
+
+var (
+	// ErrNoResponse triggered when the host does not answer a network management request in time.
+	ErrNoResponse = errors.New("no network management response")
+	// ErrRejected triggered when the host answers a network management request with F39 other than 00.
+	ErrRejected = errors.New("network management request rejected")
+)
+
+// NewRequest builds a 0800 for the given F70 code,
+// terminal, STAN and transmission date time are set by the sender chain.
+func NewRequest(code string) *shared.Transaction {
+	return &shared.Transaction{
+		MTI: MTIRequest,
+		F70: code,
+	}
+}
+
+// NewResponse builds the 0810 answering a 0800 sent by the host.
+func NewResponse(req *shared.Transaction, responseCode string) *shared.Transaction {
+	return &shared.Transaction{
+		MTI: MTIResponse,
+		F7:  req.F7,
+		F11: req.F11,
+		F39: responseCode,
+		F41: req.F41,
+		F70: req.F70,
+	}
+}
+
+// Validate checks res answers req and was approved.
+func Validate(req, res *shared.Transaction) error {
+	if res == nil {
+		return fmt.Errorf("%w: F70 %s STAN %s", ErrNoResponse, req.F70, req.F11)
+	}
+	if res.F39 != ResponseApproved {
+		return fmt.Errorf("%w: F70 %s F39 %s", ErrRejected, req.F70, res.F39)
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+// Package orphan deals with franchise responses arriving when nobody waits for them anymore.
+package orphan
+
+import (
+	"encoding/json"
+	"fmt"
+	"megalink/gateway/client/correlation"
+	"megalink/gateway/client/metrics"
+	"megalink/gateway/client/reversal"
+	"megalink/gateway/client/utils"
+	"megalink/gateway/logger"
+	"megalink/gateway/shared"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// approved F39 of an approved authorisation.
+	approved = "00"
+
+	// metricOrphans counter of responses nobody waited for.
+	metricOrphans = "orphan.responses"
+	// metricLateApprovals counter of approvals received after answering TIMEOUT.
+	metricLateApprovals = "orphan.late_approvals"
+
+	orphanTag = "OrphanHandler | %s"
+)
+
+type (
+	// IOrphanHandler matches late responses with the transactions answered as TIMEOUT.
+	IOrphanHandler interface {
+		// TimedOut reverses req, answered as TIMEOUT, and remembers it to match a late response.
+		TimedOut(req *shared.Transaction)
+		// Handle journals a response nobody waits for and makes sure a late approval is reversed.
+		Handle(response *shared.Transaction)
+	}
+
+	// OrphanHandler implements IOrphanHandler with a JSON lines journal.
+	OrphanHandler struct {
+		// JournalPath JSON lines file where orphan responses are appended, not journaled if empty.
+		JournalPath string
+		// Retention time a timed out transaction is remembered.
+		Retention      time.Duration
+		CorrelationKey correlation.KeyFunc
+		Reversal       reversal.IReversalService
+		Metrics        metrics.IMetrics
+		Logger         logger.IFastLogger
+		mtx            sync.Mutex
+		// timedOut time each transaction answered as TIMEOUT was reversed, by correlation key.
+		timedOut   map[string]time.Time
+		journalMtx sync.Mutex
+		now        func() time.Time
+	}
+
+	// JournalEntry is a line of the orphan journal.
+	JournalEntry struct {
+		ReceivedAt time.Time `json:"received_at"`
+		Key        string    `json:"key"`
+		// TimedOut the response belongs to a transaction answered as TIMEOUT.
+		TimedOut bool `json:"timed_out"`
+		// LateApproval the response approves a transaction the gateway did not wait for.
+		LateApproval bool                `json:"late_approval"`
+		Message      *shared.Transaction `json:"message"`
+	}
+)
+
+// NewOrphanHandler provides an OrphanHandler.
+func NewOrphanHandler(
+	journalPath string,
+	retention time.Duration,
+	correlationKey correlation.KeyFunc,
+	reversalService reversal.IReversalService,
+	m metrics.IMetrics,
+	logger logger.IFastLogger,
+) IOrphanHandler {
+	return &OrphanHandler{
+		JournalPath:    journalPath,
+		Retention:      retention,
+		CorrelationKey: correlationKey,
+		Reversal:       reversalService,
+		Metrics:        m,
+		Logger:         logger,
+		timedOut:       make(map[string]time.Time),
+		now:            time.Now,
+	}
+}
+
+// TimedOut reverses req, the host may have approved it, and remembers it to match a late response.
+// The key is remembered first, so a late approval arriving meanwhile is not reversed twice.
+func (oh *OrphanHandler) TimedOut(req *shared.Transaction) {
+	oh.mtx.Lock()
+	oh.prune()
+	oh.timedOut[oh.CorrelationKey(req)] = oh.now()
+	oh.mtx.Unlock()
+
+	oh.Reversal.Reverse(req)
+}
+
+// Handle journals a response nobody waits for. An approval is reversed unless a reversal was already queued
+// for it, and raises an alert either way.
+func (oh *OrphanHandler) Handle(response *shared.Transaction) {
+	tag := fmt.Sprintf(orphanTag, "Handle")
+	key := oh.CorrelationKey(response)
+	oh.Metrics.Inc(metricOrphans)
+
+	oh.mtx.Lock()
+	oh.prune()
+	_, timedOut := oh.timedOut[key]
+	delete(oh.timedOut, key)
+	oh.mtx.Unlock()
+
+	lateApproval := isAuthorisationResponse(response.MTI) && response.F39 == approved
+	oh.journal(&JournalEntry{
+		ReceivedAt:   oh.now(),
+		Key:          key,
+		TimedOut:     timedOut,
+		LateApproval: lateApproval,
+		Message:      response,
+	})
+
+	if !lateApproval {
+		oh.Logger.Warning(tag, fmt.Sprintf("MTI %s F39 %s %s nobody waits for it", response.MTI, response.F39, key))
+		return
+	}
+
+	oh.Metrics.Inc(metricLateApprovals)
+	if timedOut {
+		oh.Logger.Error(tag, fmt.Sprintf("ALERT late approval %s F38 %s answered as TIMEOUT, reversal already queued", key, response.F38))
+		return
+	}
+
+	// unknown transaction, e.g. sent before a restart, reverse it from the data echoed by the host.
+	original := *response
+	original.MTI = requestMTI(response.MTI)
+	original.F38, original.F39 = "", ""
+	oh.Reversal.Reverse(&original)
+	oh.Logger.Error(tag, fmt.Sprintf("ALERT late approval %s F38 %s of an unknown transaction, reversal queued", key, response.F38))
+}
+
+// prune forgets timed out transactions older than the retention, mtx must be held.
+func (oh *OrphanHandler) prune() {
+	for key, at := range oh.timedOut {
+		if oh.now().Sub(at) > oh.Retention {
+			delete(oh.timedOut, key)
+		}
+	}
+}
+
+// journal appends entry to the journal with sensitive card data removed.
+func (oh *OrphanHandler) journal(entry *JournalEntry) {
+	if oh.JournalPath == "" {
+		return
+	}
+	tag := fmt.Sprintf(orphanTag, "journal")
+
+	message := *entry.Message
+	message.F2 = utils.MaskPAN(message.F2)
+	message.F35, message.F45, message.F52 = "", "", ""
+	entry.Message = &message
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		oh.Logger.Error(tag, err)
+		return
+	}
+
+	oh.journalMtx.Lock()
+	defer oh.journalMtx.Unlock()
+	file, err := os.OpenFile(oh.JournalPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		oh.Logger.Error(tag, err)
+		return
+	}
+	defer file.Close()
+	if _, err := file.Write(append(line, '\n')); err != nil {
+		oh.Logger.Error(tag, err)
+	}
+}
+
+// isAuthorisationResponse tells if mti answers an authorisation or a financial request, 0110 or 0210.
+func isAuthorisationResponse(mti string) bool {
+	return mti == "0110" || mti == "0210"
+}
+
+// requestMTI gets the request MTI of a response, 0210 into 0200.
+func requestMTI(mti string) string {
+	if len(mti) != 4 {
+		return mti
+	}
+	return mti[:2] + string(mti[2]-1) + "0"
+}
@@ -0,0 +1,163 @@
+package orphan
+
+import (
+	"bufio"
+	"encoding/json"
+	"megalink/gateway/client/correlation"
+	"megalink/gateway/client/metrics"
+	"megalink/gateway/shared"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// nopLogger discards the handler logs.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, interface{})   {}
+func (nopLogger) Info(string, interface{})    {}
+func (nopLogger) Warning(string, interface{}) {}
+func (nopLogger) Error(string, interface{})   {}
+func (nopLogger) WithPrefix(string)           {}
+
+// reversals records the reversals queued.
+type reversals struct {
+	queued []*shared.Transaction
+}
+
+func (r *reversals) Reverse(original *shared.Transaction) {
+	r.queued = append(r.queued, original)
+}
+
+func newTestHandler(t *testing.T, journalPath string) (*OrphanHandler, *reversals, metrics.IMetrics) {
+	t.Helper()
+	r := &reversals{}
+	m := metrics.NewMetrics()
+	oh := NewOrphanHandler(journalPath, time.Minute, correlation.NewKeyFunc(nil), r, m, nopLogger{})
+	return oh.(*OrphanHandler), r, m
+}
+
+func request() *shared.Transaction {
+	return &shared.Transaction{
+		MTI: "0200", F2: "4111111111111111", F4: "000000000100", F11: "000001",
+		F35: "4111111111111111=2812", F37: "629015000001", F41: "TERM0001",
+	}
+}
+
+func response(f39 string) *shared.Transaction {
+	res := *request()
+	res.MTI, res.F38, res.F39 = "0210", "A12345", f39
+	return &res
+}
+
+func TestLateApprovalOfTimedOutTransaction(t *testing.T) {
+	oh, r, m := newTestHandler(t, "")
+	oh.TimedOut(request())
+	if len(r.queued) != 1 {
+		t.Fatalf("%d reversals after TimedOut, want 1", len(r.queued))
+	}
+
+	// the reversal is already queued, a late approval must not queue another one.
+	oh.Handle(response("00"))
+	if len(r.queued) != 1 {
+		t.Errorf("%d reversals after the late approval, want 1", len(r.queued))
+	}
+	if got := m.Get(metricLateApprovals); got != 1 {
+		t.Errorf("late approvals = %d, want 1", got)
+	}
+
+	// the key is forgotten once matched, a duplicate is reversed as unknown.
+	oh.Handle(response("00"))
+	if len(r.queued) != 2 {
+		t.Errorf("%d reversals after a duplicate approval, want 2", len(r.queued))
+	}
+}
+
+func TestOrphanResponses(t *testing.T) {
+	tests := []struct {
+		name        string
+		response    *shared.Transaction
+		wantReverse bool
+	}{
+		{name: "unknown approval", response: response("00"), wantReverse: true},
+		{name: "unknown decline", response: response("05")},
+		{name: "reversal response", response: &shared.Transaction{MTI: "0410", F11: "000001", F39: "00"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oh, r, m := newTestHandler(t, "")
+			oh.Handle(tt.response)
+
+			if got := m.Get(metricOrphans); got != 1 {
+				t.Errorf("orphans = %d, want 1", got)
+			}
+			if !tt.wantReverse {
+				if len(r.queued) != 0 {
+					t.Errorf("%d reversals, want none", len(r.queued))
+				}
+				return
+			}
+			if len(r.queued) != 1 {
+				t.Fatalf("%d reversals, want 1", len(r.queued))
+			}
+			original := r.queued[0]
+			if original.MTI != "0200" || original.F38 != "" || original.F39 != "" || original.F11 != tt.response.F11 {
+				t.Errorf("reversed %+v, want the 0200 without F38 and F39", original)
+			}
+		})
+	}
+}
+
+func TestTimedOutKeysExpire(t *testing.T) {
+	oh, r, _ := newTestHandler(t, "")
+	now := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+	oh.now = func() time.Time { return now }
+
+	oh.TimedOut(request())
+	now = now.Add(2 * time.Minute)
+	oh.Handle(response("00"))
+
+	if len(r.queued) != 2 {
+		t.Errorf("%d reversals, want the late approval reversed again after the retention", len(r.queued))
+	}
+}
+
+func TestJournalRemovesCardData(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "orphans.jsonl")
+	oh, _, _ := newTestHandler(t, path)
+	oh.TimedOut(request())
+	oh.Handle(response("00"))
+	oh.Handle(response("05"))
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("opening journal: %v", err)
+	}
+	defer file.Close()
+	if info, _ := file.Stat(); info.Mode().Perm() != 0o600 {
+		t.Errorf("journal mode %v, want 0600", info.Mode().Perm())
+	}
+
+	var entries []JournalEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var entry JournalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("journal line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("%d journal entries, want 2", len(entries))
+	}
+	if !entries[0].TimedOut || !entries[0].LateApproval || entries[1].LateApproval {
+		t.Errorf("entries %+v %+v, want a late approval of a timed out transaction then a decline", entries[0], entries[1])
+	}
+	for _, entry := range entries {
+		if entry.Message.F2 != "411111******1111" || entry.Message.F35 != "" {
+			t.Errorf("journaled F2 %q F35 %q, want masked PAN without track", entry.Message.F2, entry.Message.F35)
+		}
+	}
+}
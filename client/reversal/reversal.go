@@ -0,0 +1,116 @@
+// Package reversal cancels authorisations whose outcome is unknown to the gateway.
+package reversal
+
+import (
+	"fmt"
+	"megalink/gateway/client/routing"
+	"megalink/gateway/client/saf"
+	"megalink/gateway/client/sequence"
+	"megalink/gateway/client/types"
+	"megalink/gateway/logger"
+	"megalink/gateway/shared"
+	"time"
+)
+
+const (
+	// MTIReversal reversal request sent when an authorisation times out.
+	MTIReversal = "0400"
+
+	reversalTag = "ReversalService | %s"
+)
+
+type (
+	// IReversalService reverses transactions.
+	IReversalService interface {
+		// Reverse queues a reversal of original, forwarded until the host acknowledges it.
+		Reverse(original *shared.Transaction)
+	}
+
+	// ReversalService implements IReversalService on top of the store-and-forward queue
+	// of the franchise serving the card.
+	ReversalService struct {
+		// Queues store-and-forward queue of every franchise by name.
+		Queues  map[string]saf.IQueue
+		Routes  routing.IRoutingTable
+		STAN    sequence.ISTANGenerator
+		EnvVars *types.EnvVars
+		Logger  logger.IFastLogger
+	}
+)
+
+// NewReversalService provides a new ReversalService.
+func NewReversalService(
+	queues map[string]saf.IQueue,
+	routes routing.IRoutingTable,
+	stan sequence.ISTANGenerator,
+	envVars *types.EnvVars,
+	logger logger.IFastLogger,
+) IReversalService {
+	return &ReversalService{
+		Queues:  queues,
+		Routes:  routes,
+		STAN:    stan,
+		EnvVars: envVars,
+		Logger:  logger,
+	}
+}
+
+// Reverse queues a reversal of original, forwarded until the host acknowledges it.
+func (rs *ReversalService) Reverse(original *shared.Transaction) {
+	tag := fmt.Sprintf(reversalTag, "Reverse")
+
+	name, err := rs.Routes.Route(original.F2)
+	if err != nil {
+		rs.Logger.Error(tag, fmt.Errorf("STAN %s: %w", original.F11, err))
+		return
+	}
+	queue, ok := rs.Queues[name]
+	if !ok {
+		rs.Logger.Error(tag, fmt.Sprintf("STAN %s: no queue for franchise %q", original.F11, name))
+		return
+	}
+
+	req, err := rs.buildReversal(original)
+	if err != nil {
+		rs.Logger.Error(tag, err)
+		return
+	}
+
+	if err := queue.Enqueue(req); err != nil {
+		rs.Logger.Error(tag, fmt.Sprintf("STAN %s not queued: %v", req.F11, err))
+	}
+}
+
+// buildReversal builds a 0400 carrying the original data elements in F90.
+func (rs *ReversalService) buildReversal(original *shared.Transaction) (*shared.Transaction, error) {
+	stan, err := rs.STAN.Next(rs.EnvVars.TerminalID)
+	if err != nil {
+		return nil, fmt.Errorf("getting stan: %w", err)
+	}
+
+	req := &shared.Transaction{
+		MTI: MTIReversal,
+		F2:  original.F2,
+		F3:  original.F3,
+		F4:  original.F4,
+		F12: original.F12,
+		F13: original.F13,
+		F14: original.F14,
+		F32: original.F32,
+		F37: original.F37,
+		F41: original.F41,
+		F42: original.F42,
+		F49: original.F49,
+	}
+	req.SetSTAN(stan)
+	req.SetTransmissionDateTime(time.Now())
+	req.SetOriginalDataElements(&shared.OriginalData{
+		MTI:                  original.MTI,
+		STAN:                 original.F11,
+		TransmissionDateTime: original.F7,
+		AcquirerID:           original.F32,
+		ForwarderID:          original.F33,
+	})
+
+	return req, nil
+}
@@ -0,0 +1,137 @@
+// Package routing picks the franchise serving a card by its BIN.
+package routing
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	// ErrNoRoute triggered when no range matches the card and there is no default franchise.
+	ErrNoRoute = errors.New("no franchise serves the card")
+)
+
+type (
+	// IRoutingTable gets the franchise serving a card.
+	IRoutingTable interface {
+		// Route gets the name of the franchise serving pan.
+		Route(pan string) (string, error)
+	}
+
+	// BINRange cards whose prefix, of the length of Low, is between Low and High.
+	BINRange struct {
+		Low       string `json:"low" yaml:"low"`
+		High      string `json:"high" yaml:"high"`
+		Franchise string `json:"franchise" yaml:"franchise"`
+	}
+
+	// Table implements IRoutingTable with BIN ranges, the longest matching range wins.
+	Table struct {
+		// Default franchise of cards out of every range, none if empty.
+		Default string     `json:"default" yaml:"default"`
+		Ranges  []BINRange `json:"ranges" yaml:"ranges"`
+	}
+)
+
+// LoadTable reads a routing table from a YAML or JSON file, the format is picked by extension.
+func LoadTable(path string) (*Table, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading routing table: %w", err)
+	}
+
+	table := &Table{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, table)
+	case ".json":
+		err = json.Unmarshal(data, table)
+	default:
+		return nil, fmt.Errorf("unsupported routing table extension %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing routing table %s: %w", path, err)
+	}
+
+	if err := table.Validate(); err != nil {
+		return nil, fmt.Errorf("routing table %s: %w", path, err)
+	}
+	return table, nil
+}
+
+// Validate checks every range and sorts them from the most specific.
+func (t *Table) Validate() error {
+	for i, r := range t.Ranges {
+		if r.Franchise == "" {
+			return fmt.Errorf("range %d: no franchise", i)
+		}
+		if r.Low == "" || len(r.Low) != len(r.High) || !isDigits(r.Low) || !isDigits(r.High) {
+			return fmt.Errorf("range %d: low %q and high %q must be digits of the same length", i, r.Low, r.High)
+		}
+		if r.Low > r.High {
+			return fmt.Errorf("range %d: low %q above high %q", i, r.Low, r.High)
+		}
+	}
+
+	sort.SliceStable(t.Ranges, func(i, j int) bool {
+		return len(t.Ranges[i].Low) > len(t.Ranges[j].Low)
+	})
+	return nil
+}
+
+// Route gets the franchise of the longest range matching pan, the default one otherwise.
+func (t *Table) Route(pan string) (string, error) {
+	for _, r := range t.Ranges {
+		if len(pan) < len(r.Low) {
+			continue
+		}
+		// same length digit strings compare as numbers.
+		prefix := pan[:len(r.Low)]
+		if prefix >= r.Low && prefix <= r.High {
+			return r.Franchise, nil
+		}
+	}
+
+	if t.Default == "" {
+		return "", ErrNoRoute
+	}
+	return t.Default, nil
+}
+
+// Franchises gets the names of every franchise referenced by the table.
+func (t *Table) Franchises() []string {
+	seen := make(map[string]bool)
+	var names []string
+	for _, name := range append([]string{t.Default}, rangeFranchises(t.Ranges)...) {
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		names = append(names, name)
+	}
+	return names
+}
+
+func rangeFranchises(ranges []BINRange) []string {
+	names := make([]string, 0, len(ranges))
+	for _, r := range ranges {
+		names = append(names, r.Franchise)
+	}
+	return names
+}
+
+func isDigits(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < '0' || s[i] > '9' {
+			return false
+		}
+	}
+	return true
+}
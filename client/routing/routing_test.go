@@ -0,0 +1,76 @@
+package routing
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestRouteLongestMatch(t *testing.T) {
+	table := &Table{
+		Default: "local",
+		Ranges: []BINRange{
+			{Low: "4", High: "4", Franchise: "visa"},
+			{Low: "51", High: "55", Franchise: "mastercard"},
+			{Low: "2221", High: "2720", Franchise: "mastercard"},
+			{Low: "456789", High: "456789", Franchise: "private"},
+		},
+	}
+	if err := table.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	tests := []struct {
+		pan  string
+		want string
+	}{
+		{pan: "4111111111111111", want: "visa"},
+		{pan: "4567891111111111", want: "private"},
+		{pan: "4567901111111111", want: "visa"},
+		{pan: "5105105105105100", want: "mastercard"},
+		{pan: "5599999999999999", want: "mastercard"},
+		{pan: "5600000000000000", want: "local"},
+		{pan: "2221000000000009", want: "mastercard"},
+		{pan: "2720999999999999", want: "mastercard"},
+		{pan: "2721000000000000", want: "local"},
+		{pan: "222", want: "local"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pan, func(t *testing.T) {
+			got, err := table.Route(tt.pan)
+			if err != nil {
+				t.Fatalf("Route: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Route = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouteWithoutDefault(t *testing.T) {
+	table := &Table{Ranges: []BINRange{{Low: "4", High: "4", Franchise: "visa"}}}
+	if _, err := table.Route("5105105105105100"); !errors.Is(err, ErrNoRoute) {
+		t.Errorf("Route error = %v, want ErrNoRoute", err)
+	}
+}
+
+func TestValidateRejectsInvalidRanges(t *testing.T) {
+	tests := []struct {
+		name string
+		r    BINRange
+	}{
+		{name: "no franchise", r: BINRange{Low: "4", High: "4"}},
+		{name: "different lengths", r: BINRange{Low: "51", High: "555", Franchise: "mastercard"}},
+		{name: "not digits", r: BINRange{Low: "5A", High: "55", Franchise: "mastercard"}},
+		{name: "low above high", r: BINRange{Low: "55", High: "51", Franchise: "mastercard"}},
+		{name: "empty", r: BINRange{Franchise: "mastercard"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table := &Table{Ranges: []BINRange{tt.r}}
+			if err := table.Validate(); err == nil {
+				t.Error("Validate succeeded, want error")
+			}
+		})
+	}
+}
@@ -0,0 +1,352 @@
+// Package saf implements a durable store-and-forward queue for reversals and advices.
+package saf
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"megalink/gateway/client/channels"
+	"megalink/gateway/client/correlation"
+	"megalink/gateway/client/sender"
+	"megalink/gateway/client/utils"
+	"megalink/gateway/logger"
+	"megalink/gateway/shared"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// StatusPending items are sent on every drain.
+	StatusPending = "pending"
+	// StatusStuck items exceeded the max retries and wait for manual handling.
+	StatusStuck = "stuck"
+
+	queueTag = "SAFQueue | %s"
+)
+
+type (
+	// Item is a message waiting for the host acknowledgement.
+	Item struct {
+		ID            string              `json:"id"`
+		Message       *shared.Transaction `json:"message"`
+		Retries       int                 `json:"retries"`
+		Status        string              `json:"status"`
+		LastError     string              `json:"last_error,omitempty"`
+		CreatedAt     time.Time           `json:"created_at"`
+		LastAttemptAt time.Time           `json:"last_attempt_at,omitempty"`
+	}
+
+	// PickFunc gets the link to send a single item through, done is called once it is acknowledged or given up.
+	PickFunc func() (writer io.Writer, done func(), err error)
+
+	// IQueue stores messages and forwards them to the franchise.
+	IQueue interface {
+		// Enqueue persists tx and triggers a drain.
+		Enqueue(tx *shared.Transaction) error
+		// Drain sends pending items in order until they are acknowledged, stuck or the link fails.
+		Drain(ctx context.Context)
+		// Items gets a copy of the items in queue, only stuck ones if stuckOnly.
+		Items(stuckOnly bool) []Item
+	}
+
+	// record is an Item as persisted, its message is sealed as it carries the card data.
+	record struct {
+		Item
+		Message []byte `json:"message"`
+	}
+
+	// Queue implements IQueue persisting items to a JSON file, their messages sealed with Key.
+	Queue struct {
+		// Path of the JSON file, in memory only if empty.
+		Path string
+		// Key AES key sealing the persisted messages, required with Path.
+		Key          []byte
+		MaxRetries   int
+		ResponseWait time.Duration
+		// Pick gets a link of the franchise, items are signed with its working key.
+		Pick PickFunc
+		// Send writes items through the sender chain.
+		Send           sender.SendFunc
+		Pending        *channels.Registry[*shared.Transaction]
+		CorrelationKey correlation.KeyFunc
+		Logger         logger.IFastLogger
+		mtx            sync.Mutex
+		items          []*Item
+		drainMtx       sync.Mutex
+	}
+)
+
+var (
+	// ErrNotAcknowledged the host did not acknowledge the message in time.
+	ErrNotAcknowledged = errors.New("message not acknowledged")
+)
+
+// NewQueue provides a Queue loading previous items from path if it exists.
+func NewQueue(
+	path string,
+	key []byte,
+	maxRetries int,
+	responseWait time.Duration,
+	pick PickFunc,
+	send sender.SendFunc,
+	pending *channels.Registry[*shared.Transaction],
+	correlationKey correlation.KeyFunc,
+	logger logger.IFastLogger,
+) (IQueue, error) {
+	q := &Queue{
+		Path:           path,
+		Key:            key,
+		MaxRetries:     maxRetries,
+		ResponseWait:   responseWait,
+		Pick:           pick,
+		Send:           send,
+		Pending:        pending,
+		CorrelationKey: correlationKey,
+		Logger:         logger,
+	}
+	if path == "" {
+		return q, nil
+	}
+	if len(key) == 0 {
+		return nil, fmt.Errorf("saf queue %s without key", path)
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return q, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading saf queue: %w", err)
+	}
+	var records []record
+	if err := json.Unmarshal(data, &records); err != nil {
+		return nil, fmt.Errorf("parsing saf queue %s: %w", path, err)
+	}
+	for _, r := range records {
+		item := r.Item
+		message, err := open(key, r.Message)
+		if err != nil {
+			return nil, fmt.Errorf("saf queue %s item %s: %w", path, item.ID, err)
+		}
+		if err := json.Unmarshal(message, &item.Message); err != nil {
+			return nil, fmt.Errorf("parsing saf queue %s item %s: %w", path, item.ID, err)
+		}
+		q.items = append(q.items, &item)
+	}
+
+	return q, nil
+}
+
+// Enqueue persists tx and triggers a drain in background.
+func (q *Queue) Enqueue(tx *shared.Transaction) error {
+	q.mtx.Lock()
+	q.items = append(q.items, &Item{
+		ID:        uuid.New().String(),
+		Message:   tx,
+		Status:    StatusPending,
+		CreatedAt: time.Now(),
+	})
+	err := q.persist()
+	q.mtx.Unlock()
+	if err != nil {
+		return err
+	}
+
+	go q.Drain(context.Background())
+	return nil
+}
+
+// Drain sends pending items in order until they are acknowledged, stuck or the link fails.
+// An item which cannot be packed is stuck at once.
+func (q *Queue) Drain(ctx context.Context) {
+	tag := fmt.Sprintf(queueTag, "Drain")
+
+	// only one drain at a time so items keep their order on the wire.
+	q.drainMtx.Lock()
+	defer q.drainMtx.Unlock()
+
+	for {
+		item := q.nextPending()
+		if item == nil || ctx.Err() != nil {
+			return
+		}
+
+		err := q.forward(item)
+		if err == nil {
+			q.Logger.Info(tag, fmt.Sprintf("%s MTI %s STAN %s acknowledged", item.ID, item.Message.MTI, item.Message.F11))
+			q.remove(item.ID)
+			continue
+		}
+
+		q.Logger.Warning(tag, fmt.Sprintf("%s MTI %s STAN %s: %v", item.ID, item.Message.MTI, item.Message.F11, err))
+		if errors.Is(err, sender.ErrWriting) {
+			// link is down, next successful connection drains again.
+			return
+		}
+	}
+}
+
+// Items gets a copy of the items in queue, only stuck ones if stuckOnly.
+func (q *Queue) Items(stuckOnly bool) []Item {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	items := make([]Item, 0, len(q.items))
+	for _, item := range q.items {
+		if stuckOnly && item.Status != StatusStuck {
+			continue
+		}
+		copied := *item
+		message := *item.Message
+		copied.Message = &message
+		items = append(items, copied)
+	}
+	return items
+}
+
+func (q *Queue) nextPending() *Item {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for _, item := range q.items {
+		if item.Status == StatusPending {
+			return item
+		}
+	}
+	return nil
+}
+
+// forward sends item once, a retransmission goes with the repeat MTI.
+func (q *Queue) forward(item *Item) error {
+	q.mtx.Lock()
+	if item.Retries > 0 {
+		item.Message.MTI = RepeatMTI(item.Message.MTI)
+	}
+	item.LastAttemptAt = time.Now()
+	message := *item.Message
+	q.mtx.Unlock()
+
+	err := q.send(&message)
+
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+	if err == nil {
+		return nil
+	}
+
+	item.LastError = err.Error()
+	switch {
+	case errors.Is(err, sender.ErrPacking):
+		// it will never be packed, retrying would block the items behind it.
+		item.Status = StatusStuck
+	case errors.Is(err, sender.ErrWriting):
+		// a message which never left while the link is down is not a retransmission.
+	default:
+		item.Retries++
+		if q.MaxRetries > 0 && item.Retries >= q.MaxRetries {
+			item.Status = StatusStuck
+		}
+	}
+	if persistErr := q.persist(); persistErr != nil {
+		q.Logger.Error(fmt.Sprintf(queueTag, "forward"), persistErr)
+	}
+	return err
+}
+
+// send writes tx and waits for its acknowledgement.
+func (q *Queue) send(tx *shared.Transaction) error {
+	pending, err := q.Pending.Register(q.CorrelationKey(tx))
+	if err != nil {
+		return err
+	}
+	defer pending.Cancel()
+
+	// no link up is the link being down, not a failed attempt.
+	writer, done, err := q.Pick()
+	if err != nil {
+		return fmt.Errorf("%w: %w", sender.ErrWriting, err)
+	}
+	defer done()
+
+	if err := q.Send(writer, tx); err != nil {
+		return err
+	}
+
+	ctxTimeOut, cancel := context.WithTimeout(context.Background(), q.ResponseWait)
+	defer cancel()
+	response, err := pending.Wait(ctxTimeOut)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrNotAcknowledged
+	}
+	if err != nil {
+		return err
+	}
+	if response.MTI != AcknowledgementMTI(tx.MTI) {
+		return fmt.Errorf("%w: unexpected response MTI %s", ErrNotAcknowledged, response.MTI)
+	}
+	return nil
+}
+
+func (q *Queue) remove(id string) {
+	q.mtx.Lock()
+	defer q.mtx.Unlock()
+
+	for i, item := range q.items {
+		if item.ID == id {
+			q.items = append(q.items[:i], q.items[i+1:]...)
+			break
+		}
+	}
+	if err := q.persist(); err != nil {
+		q.Logger.Error(fmt.Sprintf(queueTag, "remove"), err)
+	}
+}
+
+func (q *Queue) persist() error {
+	if q.Path == "" {
+		return nil
+	}
+
+	records := make([]record, 0, len(q.items))
+	for _, item := range q.items {
+		message, err := json.Marshal(item.Message)
+		if err != nil {
+			return err
+		}
+		sealed, err := seal(q.Key, message)
+		if err != nil {
+			return fmt.Errorf("sealing saf item %s: %w", item.ID, err)
+		}
+		records = append(records, record{Item: *item, Message: sealed})
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(q.Path, data); err != nil {
+		return fmt.Errorf("persisting saf queue: %w", err)
+	}
+	return nil
+}
+
+// RepeatMTI gets the retransmission MTI of an acquirer message, 0400 into 0401, 0420 into 0421.
+func RepeatMTI(mti string) string {
+	if len(mti) != 4 || mti[3] != '0' {
+		return mti
+	}
+	return mti[:3] + "1"
+}
+
+// AcknowledgementMTI gets the response MTI expected for a message or its repeat, 0401 into 0410.
+func AcknowledgementMTI(mti string) string {
+	if len(mti) != 4 {
+		return mti
+	}
+	return mti[:2] + string(mti[2]+1) + "0"
+}
@@ -0,0 +1,216 @@
+package saf
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"megalink/gateway/client/channels"
+	"megalink/gateway/client/sender"
+	"megalink/gateway/shared"
+	"testing"
+	"time"
+)
+
+// nopLogger discards the queue logs.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, interface{})   {}
+func (nopLogger) Info(string, interface{})    {}
+func (nopLogger) Warning(string, interface{}) {}
+func (nopLogger) Error(string, interface{})   {}
+func (nopLogger) WithPrefix(string)           {}
+
+// host answers the items sent by a queue.
+type host struct {
+	pending *channels.Registry[*shared.Transaction]
+	// answer tells if tx is acknowledged, sendErr if it fails to leave.
+	answer  func(tx *shared.Transaction) bool
+	sendErr error
+	sent    []string
+}
+
+func (h *host) send(_ io.Writer, tx *shared.Transaction) error {
+	if h.sendErr != nil {
+		return h.sendErr
+	}
+	h.sent = append(h.sent, tx.MTI+" "+tx.F11)
+	if h.answer == nil || h.answer(tx) {
+		h.pending.Resolve(tx.F11, &shared.Transaction{MTI: AcknowledgementMTI(tx.MTI), F11: tx.F11})
+	}
+	return nil
+}
+
+func pickLink() (io.Writer, func(), error) {
+	return io.Discard, func() {}, nil
+}
+
+func newTestQueue(t *testing.T, h *host, pick PickFunc, maxRetries int) *Queue {
+	t.Helper()
+	h.pending = channels.NewRegistry[*shared.Transaction]()
+	q, err := NewQueue(
+		"", nil, maxRetries, 20*time.Millisecond, pick, h.send, h.pending,
+		func(tx *shared.Transaction) string { return tx.F11 }, nopLogger{},
+	)
+	if err != nil {
+		t.Fatalf("NewQueue: %v", err)
+	}
+	return q.(*Queue)
+}
+
+// add queues messages without draining them.
+func add(q *Queue, messages ...*shared.Transaction) {
+	for i, tx := range messages {
+		q.items = append(q.items, &Item{ID: fmt.Sprint(i), Message: tx, Status: StatusPending})
+	}
+}
+
+func TestDrainForwardsInOrder(t *testing.T) {
+	h := &host{}
+	q := newTestQueue(t, h, pickLink, 0)
+	add(q,
+		&shared.Transaction{MTI: "0420", F11: "000001"},
+		&shared.Transaction{MTI: "0220", F11: "000002"},
+		&shared.Transaction{MTI: "0400", F11: "000003"},
+	)
+
+	q.Drain(context.Background())
+
+	want := []string{"0420 000001", "0220 000002", "0400 000003"}
+	if fmt.Sprint(h.sent) != fmt.Sprint(want) {
+		t.Errorf("sent %v, want %v", h.sent, want)
+	}
+	if items := q.Items(false); len(items) != 0 {
+		t.Errorf("%d items left, want 0", len(items))
+	}
+}
+
+func TestDrainRetransmitsWithRepeatMTI(t *testing.T) {
+	attempts := 0
+	h := &host{answer: func(*shared.Transaction) bool {
+		attempts++
+		return attempts == 3
+	}}
+	q := newTestQueue(t, h, pickLink, 5)
+	add(q, &shared.Transaction{MTI: "0420", F11: "000001"})
+
+	q.Drain(context.Background())
+
+	want := []string{"0420 000001", "0421 000001", "0421 000001"}
+	if fmt.Sprint(h.sent) != fmt.Sprint(want) {
+		t.Errorf("sent %v, want %v", h.sent, want)
+	}
+	if items := q.Items(false); len(items) != 0 {
+		t.Errorf("%d items left, want 0", len(items))
+	}
+}
+
+func TestDrainMarksItemsStuck(t *testing.T) {
+	tests := []struct {
+		name        string
+		h           *host
+		wantSent    int
+		wantRetries int
+	}{
+		{
+			name:        "max retries",
+			h:           &host{answer: func(*shared.Transaction) bool { return false }},
+			wantSent:    2,
+			wantRetries: 2,
+		},
+		{
+			name: "not packable",
+			h:    &host{sendErr: fmt.Errorf("%w: bad F4", sender.ErrPacking)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(t, tt.h, pickLink, 2)
+			add(q,
+				&shared.Transaction{MTI: "0420", F11: "000001"},
+				&shared.Transaction{MTI: "0420", F11: "000002"},
+			)
+
+			q.Drain(context.Background())
+
+			stuck := q.Items(true)
+			if len(stuck) != 2 {
+				t.Fatalf("%d stuck items, want 2", len(stuck))
+			}
+			if stuck[0].Retries != tt.wantRetries {
+				t.Errorf("retries = %d, want %d", stuck[0].Retries, tt.wantRetries)
+			}
+			if len(tt.h.sent) != tt.wantSent*2 {
+				t.Errorf("sent %v, want %d messages", tt.h.sent, tt.wantSent*2)
+			}
+		})
+	}
+}
+
+func TestDrainStopsWhileLinkIsDown(t *testing.T) {
+	tests := []struct {
+		name string
+		h    *host
+		pick PickFunc
+	}{
+		{
+			name: "no link",
+			h:    &host{},
+			pick: func() (io.Writer, func(), error) { return nil, nil, errors.New("no connection") },
+		},
+		{
+			name: "write failure",
+			h:    &host{sendErr: fmt.Errorf("%w: broken pipe", sender.ErrWriting)},
+			pick: pickLink,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := newTestQueue(t, tt.h, tt.pick, 1)
+			add(q,
+				&shared.Transaction{MTI: "0420", F11: "000001"},
+				&shared.Transaction{MTI: "0420", F11: "000002"},
+			)
+
+			q.Drain(context.Background())
+
+			items := q.Items(false)
+			if len(items) != 2 {
+				t.Fatalf("%d items, want 2", len(items))
+			}
+			for _, item := range items {
+				if item.Status != StatusPending || item.Retries != 0 {
+					t.Errorf("item %s %s with %d retries, want pending without retries", item.ID, item.Status, item.Retries)
+				}
+			}
+			if len(tt.h.sent) != 0 {
+				t.Errorf("sent %v, want nothing", tt.h.sent)
+			}
+		})
+	}
+}
+
+func TestMTIs(t *testing.T) {
+	tests := []struct {
+		mti         string
+		repeat      string
+		acknowledge string
+	}{
+		{mti: "0400", repeat: "0401", acknowledge: "0410"},
+		{mti: "0401", repeat: "0401", acknowledge: "0410"},
+		{mti: "0420", repeat: "0421", acknowledge: "0430"},
+		{mti: "0220", repeat: "0221", acknowledge: "0230"},
+		{mti: "042", repeat: "042", acknowledge: "042"},
+	}
+
+	for _, tt := range tests {
+		if got := RepeatMTI(tt.mti); got != tt.repeat {
+			t.Errorf("RepeatMTI(%q) = %q, want %q", tt.mti, got, tt.repeat)
+		}
+		if got := AcknowledgementMTI(tt.mti); got != tt.acknowledge {
+			t.Errorf("AcknowledgementMTI(%q) = %q, want %q", tt.mti, got, tt.acknowledge)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package saf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrSealedMessage triggered when a persisted message cannot be opened with the queue key.
+var ErrSealedMessage = errors.New("cannot open sealed saf message")
+
+// ParseKey decodes an hex AES key of 16, 24 or 32 bytes sealing the persisted messages.
+func ParseKey(key string) ([]byte, error) {
+	keyBytes, err := hex.DecodeString(key)
+	if err != nil {
+		return nil, fmt.Errorf("saf key is not hex: %w", err)
+	}
+	if _, err := aes.NewCipher(keyBytes); err != nil {
+		return nil, fmt.Errorf("saf key: %w", err)
+	}
+	return keyBytes, nil
+}
+
+// seal encrypts data with AES-GCM, the nonce goes first.
+func seal(key, data []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, data, nil), nil
+}
+
+// open decrypts data sealed by seal.
+func open(key, sealed []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, ErrSealedMessage
+	}
+	data, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSealedMessage, err)
+	}
+	return data, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
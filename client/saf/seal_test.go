@@ -0,0 +1,112 @@
+package saf
+
+import (
+	"bytes"
+	"errors"
+	"megalink/gateway/shared"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testKey = "00112233445566778899aabbccddeeff"
+
+func TestParseKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     string
+		wantErr bool
+	}{
+		{name: "aes-128", key: testKey},
+		{name: "aes-256", key: testKey + testKey},
+		{name: "not hex", key: "zz112233445566778899aabbccddeeff", wantErr: true},
+		{name: "short", key: "0011223344", wantErr: true},
+		{name: "empty", key: "", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := ParseKey(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("ParseKey error = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSealOpen(t *testing.T) {
+	key, _ := ParseKey(testKey)
+	other, _ := ParseKey(testKey[2:] + "00")
+	data := []byte(`{"f2":"4111111111111111"}`)
+
+	sealed, err := seal(key, data)
+	if err != nil {
+		t.Fatalf("seal: %v", err)
+	}
+	if bytes.Contains(sealed, data[7:20]) {
+		t.Error("sealed message contains the PAN")
+	}
+	if again, _ := seal(key, data); bytes.Equal(again, sealed) {
+		t.Error("sealing twice gave the same bytes, the nonce is not random")
+	}
+
+	opened, err := open(key, sealed)
+	if err != nil || !bytes.Equal(opened, data) {
+		t.Fatalf("open = %q, %v, want %q", opened, err, data)
+	}
+
+	tampered := append([]byte(nil), sealed...)
+	tampered[len(tampered)-1] ^= 1
+	for name, tt := range map[string]struct {
+		key    []byte
+		sealed []byte
+	}{
+		"wrong key": {key: other, sealed: sealed},
+		"tampered":  {key: key, sealed: tampered},
+		"truncated": {key: key, sealed: sealed[:4]},
+	} {
+		if _, err := open(tt.key, tt.sealed); !errors.Is(err, ErrSealedMessage) {
+			t.Errorf("%s: open error = %v, want ErrSealedMessage", name, err)
+		}
+	}
+}
+
+func TestQueuePersistsSealedMessages(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "saf.json")
+	key, _ := ParseKey(testKey)
+
+	h := &host{}
+	q := newTestQueue(t, h, pickLink, 0)
+	q.Path, q.Key = path, key
+	add(q, &shared.Transaction{MTI: "0420", F2: "4111111111111111", F11: "000001"})
+	if err := q.persist(); err != nil {
+		t.Fatalf("persist: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading queue: %v", err)
+	}
+	if bytes.Contains(data, []byte("4111111111111111")) {
+		t.Error("persisted queue contains the PAN")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("queue file mode %v, want 0600", info.Mode().Perm())
+	}
+
+	reloaded, err := NewQueue(path, key, 0, 0, pickLink, h.send, h.pending, nil, nopLogger{})
+	if err != nil {
+		t.Fatalf("NewQueue: %v", err)
+	}
+	items := reloaded.Items(false)
+	if len(items) != 1 || items[0].Message.F2 != "4111111111111111" {
+		t.Fatalf("reloaded items %+v, want the queued message", items)
+	}
+
+	other, _ := ParseKey(testKey[2:] + "00")
+	if _, err := NewQueue(path, other, 0, 0, pickLink, h.send, h.pending, nil, nopLogger{}); !errors.Is(err, ErrSealedMessage) {
+		t.Errorf("NewQueue with another key error = %v, want ErrSealedMessage", err)
+	}
+	if _, err := NewQueue(path, nil, 0, 0, pickLink, h.send, h.pending, nil, nopLogger{}); err == nil {
+		t.Error("NewQueue with a path and no key succeeded, want error")
+	}
+}
@@ -0,0 +1,98 @@
+package sender
+
+import (
+	"crypto/des"
+	"encoding/hex"
+	"fmt"
+	"megalink/gateway/shared"
+	"megalink/gateway/shared/codec"
+	"strings"
+)
+
+const (
+	// macField used when the message has no secondary bitmap.
+	macField = 64
+	// secondaryMACField used when the message has a secondary bitmap.
+	secondaryMACField = 128
+)
+
+// SignMAC sets F64, or F128 when there are secondary fields, to the ANSI X9.19 retail MAC of tx.
+// key is an hex single, double or triple length DES key. The MAC covers the message as encoded by
+// messageCodec up to the MAC field, bitmap included, which is what the host verifies.
+func SignMAC(tx *shared.Transaction, key string, messageCodec codec.Codec) error {
+	keyBytes, err := hex.DecodeString(key)
+	if err != nil {
+		return fmt.Errorf("mac key is not hex: %w", err)
+	}
+
+	tx.F64, tx.F128 = "", ""
+	field := macField
+	for n := range tx.Fields() {
+		if n > macField {
+			field = secondaryMACField
+			break
+		}
+	}
+
+	data, err := messageCodec.MACData(tx, field)
+	if err != nil {
+		return fmt.Errorf("%w MAC data of MTI %s: %w", ErrPacking, tx.MTI, err)
+	}
+	mac, err := retailMAC(keyBytes, data)
+	if err != nil {
+		return err
+	}
+
+	tx.SetField(field, strings.ToUpper(hex.EncodeToString(mac)))
+	return nil
+}
+
+// retailMAC computes ISO 9797-1 MAC algorithm 3 with zero padding, single DES CBC-MAC for 8 bytes keys.
+func retailMAC(key, data []byte) ([]byte, error) {
+	var k1, k2, k3 []byte
+	switch len(key) {
+	case 8:
+		k1 = key
+	case 16:
+		k1, k2, k3 = key[:8], key[8:], key[:8]
+	case 24:
+		k1, k2, k3 = key[:8], key[8:16], key[16:]
+	default:
+		return nil, fmt.Errorf("mac key must be 8, 16 or 24 bytes, got %d", len(key))
+	}
+
+	c1, err := des.NewCipher(k1)
+	if err != nil {
+		return nil, err
+	}
+
+	if rem := len(data) % des.BlockSize; rem != 0 || len(data) == 0 {
+		// padded in a copy, data may be a slice of the caller message.
+		padded := make([]byte, len(data)+des.BlockSize-rem)
+		copy(padded, data)
+		data = padded
+	}
+
+	mac := make([]byte, des.BlockSize)
+	for i := 0; i < len(data); i += des.BlockSize {
+		for j := 0; j < des.BlockSize; j++ {
+			mac[j] ^= data[i+j]
+		}
+		c1.Encrypt(mac, mac)
+	}
+	if k2 == nil {
+		return mac, nil
+	}
+
+	c2, err := des.NewCipher(k2)
+	if err != nil {
+		return nil, err
+	}
+	c3, err := des.NewCipher(k3)
+	if err != nil {
+		return nil, err
+	}
+	c2.Decrypt(mac, mac)
+	c3.Encrypt(mac, mac)
+	return mac, nil
+}
@@ -0,0 +1,134 @@
+package sender
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io"
+	"megalink/gateway/shared"
+	"megalink/gateway/shared/codec"
+	"strings"
+	"testing"
+)
+
+func TestRetailMACVectors(t *testing.T) {
+	tests := []struct {
+		name string
+		key  string
+		data string
+		want string
+	}{
+		// ANSI X9.19 double length key example.
+		{name: "x9.19", key: "0123456789ABCDEFFEDCBA9876543210", data: "Now is the time for all ", want: "A1C72E74EA3FA9B6"},
+		// ANSI X9.9 single length key example, zero padded.
+		{name: "x9.9", key: "0123456789ABCDEF", data: "7654321 Now is the time for ", want: "F1D30F6849312CA4"},
+		{name: "triple length", key: "0123456789ABCDEFFEDCBA98765432100123456789ABCDEF", data: "Now is the time for all ", want: "A1C72E74EA3FA9B6"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, _ := hex.DecodeString(tt.key)
+			mac, err := retailMAC(key, []byte(tt.data))
+			if err != nil {
+				t.Fatalf("retailMAC: %v", err)
+			}
+			if got := strings.ToUpper(hex.EncodeToString(mac)); got != tt.want {
+				t.Errorf("retailMAC = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSignMACCoversEncodedMessage(t *testing.T) {
+	const key = "0123456789ABCDEFFEDCBA9876543210"
+	tests := []struct {
+		name  string
+		codec string
+		tx    shared.Transaction
+		field int
+	}{
+		{name: "ascii primary", codec: codec.ISO8583ASCII, tx: shared.Transaction{MTI: "0200", F2: "4111111111111111", F4: "000000000100", F11: "000001"}, field: 64},
+		{name: "binary primary", codec: codec.ISO8583Binary, tx: shared.Transaction{MTI: "0200", F2: "4111111111111111", F4: "000000000100", F11: "000001"}, field: 64},
+		{name: "ascii secondary", codec: codec.ISO8583ASCII, tx: shared.Transaction{MTI: "0400", F11: "000002", F90: strings.Repeat("0", 42)}, field: 128},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			messageCodec, err := codec.NewCodec(tt.codec, "")
+			if err != nil {
+				t.Fatalf("NewCodec: %v", err)
+			}
+			tx := tt.tx
+			if err := SignMAC(&tx, key, messageCodec); err != nil {
+				t.Fatalf("SignMAC: %v", err)
+			}
+			if tx.Field(tt.field) == "" {
+				t.Fatalf("F%d not set", tt.field)
+			}
+
+			// the host verifies the MAC over the wire bytes preceding it.
+			encoded, err := messageCodec.Encode(&tx)
+			if err != nil {
+				t.Fatalf("Encode: %v", err)
+			}
+			keyBytes, _ := hex.DecodeString(key)
+			want, _ := retailMAC(keyBytes, encoded[:len(encoded)-8])
+			if got := encoded[len(encoded)-8:]; !bytes.Equal(got, want) {
+				t.Errorf("MAC on the wire = %X, want %X", got, want)
+			}
+		})
+	}
+}
+
+// keyWriter is a link with a working key.
+type keyWriter struct {
+	bytes.Buffer
+	key string
+}
+
+func (kw *keyWriter) WorkingKey() string { return kw.key }
+
+func TestMACMiddleware(t *testing.T) {
+	messageCodec, _ := codec.NewCodec(codec.ISO8583ASCII, "")
+	tests := []struct {
+		name    string
+		writer  *keyWriter
+		mti     string
+		wantErr error
+		signed  bool
+	}{
+		{name: "signed", writer: &keyWriter{key: "0123456789ABCDEF"}, mti: "0200", signed: true},
+		{name: "no working key", writer: &keyWriter{}, mti: "0200", wantErr: ErrNoWorkingKey},
+		{name: "network management", writer: &keyWriter{}, mti: "0800"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sent := false
+			send := new(SenderChain).
+				AddMiddleware(MAC(messageCodec)).
+				BuildChain(func(_ io.Writer, _ *shared.Transaction) error {
+					sent = true
+					return nil
+				})
+
+			tx := &shared.Transaction{MTI: tt.mti, F11: "000001"}
+			err := send(tt.writer, tx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("send error = %v, want %v", err, tt.wantErr)
+			}
+			if sent != (tt.wantErr == nil) {
+				t.Errorf("sent = %v, want %v", sent, tt.wantErr == nil)
+			}
+			if signed := tx.F64 != ""; signed != tt.signed {
+				t.Errorf("signed = %v, want %v", signed, tt.signed)
+			}
+		})
+	}
+
+	if err := new(SenderChain).AddMiddleware(MAC(messageCodec)).BuildChain(Encode(messageCodec))(
+		&bytes.Buffer{}, &shared.Transaction{MTI: "0200", F11: "000001"},
+	); !errors.Is(err, ErrNoWorkingKey) {
+		t.Errorf("send to a writer without key error = %v, want ErrNoWorkingKey", err)
+	}
+}
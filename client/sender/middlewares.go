@@ -0,0 +1,119 @@
+package sender
+
+import (
+	"fmt"
+	"io"
+	"megalink/gateway/client/metrics"
+	"megalink/gateway/client/sequence"
+	"megalink/gateway/client/types"
+	"megalink/gateway/logger"
+	"megalink/gateway/shared"
+	"megalink/gateway/shared/codec"
+	"strings"
+	"time"
+)
+
+const (
+	// metricSent counter of messages sent, suffixed by their MTI.
+	metricSent = "sender.sent."
+	// metricErrors counter of messages which could not be sent.
+	metricErrors = "sender.errors"
+
+	senderTag = "Sender | %s"
+)
+
+// DefaultFields fills the terminal, merchant, acquirer and transmission date time when left empty.
+// Network management messages only get the terminal and the transmission date time.
+func DefaultFields(envVars *types.EnvVars) MiddlewareFunc {
+	return func(next SendFunc) SendFunc {
+		return func(writer io.Writer, tx *shared.Transaction) error {
+			if tx.F41 == "" {
+				tx.F41 = envVars.TerminalID
+			}
+			if tx.F7 == "" {
+				tx.SetTransmissionDateTime(time.Now())
+			}
+			if !strings.HasPrefix(tx.MTI, "08") {
+				if tx.F32 == "" {
+					tx.F32 = envVars.AcquirerID
+				}
+				if tx.F42 == "" {
+					tx.F42 = envVars.MerchantID
+				}
+			}
+			return next(writer, tx)
+		}
+	}
+}
+
+// AssignSTAN gives the next STAN of the terminal to messages without one.
+func AssignSTAN(stan sequence.ISTANGenerator, terminalID string) MiddlewareFunc {
+	return func(next SendFunc) SendFunc {
+		return func(writer io.Writer, tx *shared.Transaction) error {
+			if tx.F11 == "" {
+				value, err := stan.Next(terminalID)
+				if err != nil {
+					return fmt.Errorf("getting stan: %w", err)
+				}
+				tx.SetSTAN(value)
+			}
+			return next(writer, tx)
+		}
+	}
+}
+
+// KeyHolder is a writer knowing the MAC working key of its franchise link.
+type KeyHolder interface {
+	WorkingKey() string
+}
+
+// MAC signs messages with the working key of the writer link, network management messages excepted as the
+// key is exchanged through them. A message is never sent unsigned, ErrNoWorkingKey is returned instead.
+func MAC(messageCodec codec.Codec) MiddlewareFunc {
+	return func(next SendFunc) SendFunc {
+		return func(writer io.Writer, tx *shared.Transaction) error {
+			if strings.HasPrefix(tx.MTI, "08") {
+				return next(writer, tx)
+			}
+			holder, ok := writer.(KeyHolder)
+			if !ok || holder.WorkingKey() == "" {
+				return fmt.Errorf("%w: MTI %s STAN %s", ErrNoWorkingKey, tx.MTI, tx.F11)
+			}
+			if err := SignMAC(tx, holder.WorkingKey(), messageCodec); err != nil {
+				return err
+			}
+			return next(writer, tx)
+		}
+	}
+}
+
+// Logging logs every message sent and every failure.
+func Logging(log logger.IFastLogger) MiddlewareFunc {
+	return func(next SendFunc) SendFunc {
+		return func(writer io.Writer, tx *shared.Transaction) error {
+			tag := fmt.Sprintf(senderTag, "Logging")
+			err := next(writer, tx)
+			if err != nil {
+				log.Error(tag, fmt.Sprintf("MTI %s STAN %s: %v", tx.MTI, tx.F11, err))
+				return err
+			}
+			log.Info(tag, fmt.Sprintf("MTI %s STAN %s sent", tx.MTI, tx.F11))
+			return nil
+		}
+	}
+}
+
+// Metrics counts messages sent by MTI and failures.
+func Metrics(m metrics.IMetrics) MiddlewareFunc {
+	return func(next SendFunc) SendFunc {
+		return func(writer io.Writer, tx *shared.Transaction) error {
+			err := next(writer, tx)
+			if err != nil {
+				m.Inc(metricErrors)
+				return err
+			}
+			m.Inc(metricSent + tx.MTI)
+			return nil
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Package sender builds the pipeline every message goes through before being written to the franchise.
+package sender
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"megalink/gateway/shared"
+	"megalink/gateway/shared/codec"
+)
+
+type (
+	// SendFunc writes a message to the franchise.
+	SendFunc func(io.Writer, *shared.Transaction) error
+
+	// MiddlewareFunc it's a closure which returns a SendFunc running before next.
+	MiddlewareFunc func(next SendFunc) SendFunc
+
+	// SenderChain is a set of middleware functions applied to the messages sent to the franchise.
+	SenderChain struct {
+		middlewares []MiddlewareFunc
+	}
+)
+
+var (
+	// ErrPacking triggered when a message cannot be encoded, sending it again does not help.
+	ErrPacking = errors.New("packing transaction")
+	// ErrWriting triggered when an encoded message could not be written to the franchise.
+	ErrWriting = errors.New("writing transaction")
+	// ErrNoWorkingKey triggered when the link has no MAC working key yet, it is not up for financial messages.
+	ErrNoWorkingKey = fmt.Errorf("%w: no MAC working key", ErrWriting)
+)
+
+// AddMiddleware appends a new middleware to the middlewares set.
+func (ch *SenderChain) AddMiddleware(m MiddlewareFunc) *SenderChain {
+	ch.middlewares = append(ch.middlewares, m)
+	return ch
+}
+
+// BuildChain gets the first SendFunc of the chain, last being the one writing the message.
+func (ch *SenderChain) BuildChain(last SendFunc) SendFunc {
+	head := last
+	for i := len(ch.middlewares) - 1; i >= 0; i-- {
+		head = ch.middlewares[i](head)
+	}
+
+	// deletes current middlewares
+	ch.middlewares = nil
+	return head
+}
+
+// Encode is the last SendFunc of a chain, it encodes the message and writes it in a single call.
+func Encode(messageCodec codec.Codec) SendFunc {
+	return func(writer io.Writer, tx *shared.Transaction) error {
+		requestBytes, err := messageCodec.Encode(tx)
+		if err != nil {
+			return fmt.Errorf("%w MTI %s: %w", ErrPacking, tx.MTI, err)
+		}
+		if _, err := writer.Write(requestBytes); err != nil {
+			return fmt.Errorf("%w MTI %s: %w", ErrWriting, tx.MTI, err)
+		}
+		return nil
+	}
+}
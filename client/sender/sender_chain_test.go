@@ -0,0 +1,74 @@
+package sender
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"megalink/gateway/shared"
+	"megalink/gateway/shared/codec"
+	"testing"
+)
+
+func TestBuildChainOrder(t *testing.T) {
+	var calls []string
+	middleware := func(name string) MiddlewareFunc {
+		return func(next SendFunc) SendFunc {
+			return func(writer io.Writer, tx *shared.Transaction) error {
+				calls = append(calls, name)
+				return next(writer, tx)
+			}
+		}
+	}
+
+	send := new(SenderChain).
+		AddMiddleware(middleware("first")).
+		AddMiddleware(middleware("second")).
+		BuildChain(func(io.Writer, *shared.Transaction) error {
+			calls = append(calls, "last")
+			return nil
+		})
+	if err := send(io.Discard, &shared.Transaction{}); err != nil {
+		t.Fatalf("send: %v", err)
+	}
+
+	want := []string{"first", "second", "last"}
+	if len(calls) != len(want) {
+		t.Fatalf("calls = %v, want %v", calls, want)
+	}
+	for i := range want {
+		if calls[i] != want[i] {
+			t.Fatalf("calls = %v, want %v", calls, want)
+		}
+	}
+}
+
+// failingWriter fails every write.
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, io.ErrClosedPipe }
+
+func TestEncodeErrors(t *testing.T) {
+	messageCodec, _ := codec.NewCodec(codec.ISO8583ASCII, "")
+	tests := []struct {
+		name    string
+		writer  io.Writer
+		tx      *shared.Transaction
+		wantErr error
+	}{
+		{name: "written", writer: &bytes.Buffer{}, tx: &shared.Transaction{MTI: "0200", F11: "000001"}},
+		{name: "not packable", writer: &bytes.Buffer{}, tx: &shared.Transaction{MTI: "0200", F4: "1A"}, wantErr: ErrPacking},
+		{name: "write failure", writer: failingWriter{}, tx: &shared.Transaction{MTI: "0200", F11: "000001"}, wantErr: ErrWriting},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Encode(messageCodec)(tt.writer, tt.tx)
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("Encode error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+	if err := Encode(messageCodec)(failingWriter{}, &shared.Transaction{MTI: "0200"}); !errors.Is(err, io.ErrClosedPipe) {
+		t.Errorf("Encode error = %v, want the write error wrapped", err)
+	}
+}
@@ -0,0 +1,42 @@
+// Package sequence provides the trace numbers identifying every message sent to the franchise.
+package sequence
+
+import (
+	"fmt"
+	"time"
+)
+
+const (
+	// maxSTAN STAN is 6 digits, it rolls over to 1 after this value.
+	maxSTAN = 999999
+)
+
+type (
+	// ISTANGenerator provides F11 system trace audit numbers.
+	ISTANGenerator interface {
+		Next(terminalID string) (int, error)
+	}
+
+	// STANGenerator implements ISTANGenerator with a sequence per terminal.
+	STANGenerator struct {
+		Store IStore
+	}
+)
+
+// NewSTANGenerator provides a new STANGenerator backed by store.
+func NewSTANGenerator(store IStore) ISTANGenerator {
+	return &STANGenerator{
+		Store: store,
+	}
+}
+
+// Next gets the next STAN of terminalID, 000000 is never used.
+func (sg *STANGenerator) Next(terminalID string) (int, error) {
+	return sg.Store.Next("stan:"+terminalID, maxSTAN)
+}
+
+// RRN builds a F37 retrieval reference number as YDDDhh followed by the STAN.
+func RRN(t time.Time, stan int) string {
+	t = t.UTC()
+	return fmt.Sprintf("%d%03d%02d%06d", t.Year()%10, t.YearDay(), t.Hour(), stan)
+}
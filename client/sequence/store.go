@@ -0,0 +1,132 @@
+package sequence
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"megalink/gateway/client/utils"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	businessDateLayout = "20060102"
+)
+
+type (
+	// IStore keeps sequences across restarts and resets them every business day.
+	IStore interface {
+		// Next increments sequence name, rolling over to 1 after max.
+		Next(name string, max int) (int, error)
+	}
+
+	// Store implements IStore in memory, persisting to a JSON file when Path is set.
+	// Values are reserved in blocks of BlockSize so only one Next in a block writes the file,
+	// after a restart the values left in the last block are skipped.
+	Store struct {
+		// Path of the JSON file, in memory only if empty.
+		Path string
+		// CutoverHour local hour at which a new business day starts and sequences go back to 1.
+		CutoverHour int
+		// BlockSize values reserved on every write of the file.
+		BlockSize int
+		mtx       sync.Mutex
+		state     storeState
+		// issued values handed out by every sequence in the business day, before rolling over.
+		issued map[string]int
+		now    func() time.Time
+	}
+
+	storeState struct {
+		BusinessDate string `json:"business_date"`
+		// Sequences values reserved by every sequence in the business day, the next start after a restart.
+		Sequences map[string]int `json:"sequences"`
+	}
+)
+
+// NewStore provides a Store loading previous sequences from path if it exists, blockSize is at least 1.
+func NewStore(path string, cutoverHour int, blockSize int) (IStore, error) {
+	if cutoverHour < 0 || cutoverHour > 23 {
+		return nil, fmt.Errorf("invalid cutover hour %d", cutoverHour)
+	}
+
+	if blockSize < 1 {
+		blockSize = 1
+	}
+
+	st := &Store{
+		Path:        path,
+		CutoverHour: cutoverHour,
+		BlockSize:   blockSize,
+		state:       storeState{Sequences: make(map[string]int)},
+		issued:      make(map[string]int),
+		now:         time.Now,
+	}
+	if path == "" {
+		return st, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return st, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading sequence store: %w", err)
+	}
+	if err := json.Unmarshal(data, &st.state); err != nil {
+		return nil, fmt.Errorf("parsing sequence store %s: %w", path, err)
+	}
+	if st.state.Sequences == nil {
+		st.state.Sequences = make(map[string]int)
+	}
+	// the values reserved before the restart may have been used.
+	for name, reserved := range st.state.Sequences {
+		st.issued[name] = reserved
+	}
+
+	return st, nil
+}
+
+// Next increments sequence name, a new block is persisted before handing out its first value.
+func (st *Store) Next(name string, max int) (int, error) {
+	st.mtx.Lock()
+	defer st.mtx.Unlock()
+
+	businessDate := BusinessDate(st.now(), st.CutoverHour)
+	if businessDate != st.state.BusinessDate {
+		st.state = storeState{BusinessDate: businessDate, Sequences: make(map[string]int)}
+		st.issued = make(map[string]int)
+	}
+
+	issued := st.issued[name]
+	if issued >= st.state.Sequences[name] {
+		st.state.Sequences[name] = issued + st.BlockSize
+		if err := st.persist(); err != nil {
+			st.state.Sequences[name] = issued
+			return 0, err
+		}
+	}
+	st.issued[name] = issued + 1
+	return issued%max + 1, nil
+}
+
+// BusinessDate gets the business day t belongs to, which starts at cutoverHour local time.
+func BusinessDate(t time.Time, cutoverHour int) string {
+	return t.Add(-time.Duration(cutoverHour) * time.Hour).Format(businessDateLayout)
+}
+
+func (st *Store) persist() error {
+	if st.Path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(st.state)
+	if err != nil {
+		return err
+	}
+	if err := utils.WriteFileAtomic(st.Path, data); err != nil {
+		return fmt.Errorf("persisting sequence store: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package sequence
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestStoreNextRollsOver(t *testing.T) {
+	store, err := NewStore("", 0, 10)
+	if err != nil {
+		t.Fatalf("NewStore: %v", err)
+	}
+
+	want := []int{1, 2, 3, 1, 2}
+	for i, w := range want {
+		got, err := store.Next("stan", 3)
+		if err != nil {
+			t.Fatalf("Next: %v", err)
+		}
+		if got != w {
+			t.Errorf("Next #%d = %d, want %d", i, got, w)
+		}
+	}
+}
+
+func TestStoreCutoverReset(t *testing.T) {
+	tests := []struct {
+		name        string
+		cutoverHour int
+		first       time.Time
+		second      time.Time
+		want        int
+	}{
+		{
+			name:   "same day",
+			first:  time.Date(2026, 10, 17, 8, 0, 0, 0, time.Local),
+			second: time.Date(2026, 10, 17, 23, 59, 0, 0, time.Local),
+			want:   2,
+		},
+		{
+			name:   "midnight",
+			first:  time.Date(2026, 10, 17, 23, 59, 0, 0, time.Local),
+			second: time.Date(2026, 10, 18, 0, 0, 0, 0, time.Local),
+			want:   1,
+		},
+		{
+			name:        "before cutover hour",
+			cutoverHour: 6,
+			first:       time.Date(2026, 10, 17, 23, 0, 0, 0, time.Local),
+			second:      time.Date(2026, 10, 18, 5, 59, 0, 0, time.Local),
+			want:        2,
+		},
+		{
+			name:        "at cutover hour",
+			cutoverHour: 6,
+			first:       time.Date(2026, 10, 18, 5, 59, 0, 0, time.Local),
+			second:      time.Date(2026, 10, 18, 6, 0, 0, 0, time.Local),
+			want:        1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st, err := NewStore("", tt.cutoverHour, 1)
+			if err != nil {
+				t.Fatalf("NewStore: %v", err)
+			}
+			store := st.(*Store)
+
+			store.now = func() time.Time { return tt.first }
+			if _, err := store.Next("stan", maxSTAN); err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			store.now = func() time.Time { return tt.second }
+			got, err := store.Next("stan", maxSTAN)
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Next = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestStoreSkipsReservedBlockAfterRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sequences.json")
+	tests := []struct {
+		name      string
+		blockSize int
+		issued    int
+		want      int
+	}{
+		{name: "within first block", blockSize: 10, issued: 3, want: 11},
+		{name: "second block", blockSize: 10, issued: 12, want: 21},
+		{name: "block of one", blockSize: 1, issued: 3, want: 4},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store, err := NewStore(path+tt.name, 0, tt.blockSize)
+			if err != nil {
+				t.Fatalf("NewStore: %v", err)
+			}
+			for i := 0; i < tt.issued; i++ {
+				if _, err := store.Next("stan", maxSTAN); err != nil {
+					t.Fatalf("Next: %v", err)
+				}
+			}
+
+			restarted, err := NewStore(path+tt.name, 0, tt.blockSize)
+			if err != nil {
+				t.Fatalf("NewStore after restart: %v", err)
+			}
+			got, err := restarted.Next("stan", maxSTAN)
+			if err != nil {
+				t.Fatalf("Next: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Next after restart = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNewStoreRejectsInvalidCutoverHour(t *testing.T) {
+	for _, hour := range []int{-1, 24} {
+		if _, err := NewStore("", hour, 1); err == nil {
+			t.Errorf("NewStore with cutover hour %d succeeded, want error", hour)
+		}
+	}
+}
@@ -0,0 +1,34 @@
+package service
+
+import (
+	"megalink/gateway/client/saf"
+	"megalink/gateway/client/utils"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AdminService exposes operational endpoints.
+type AdminService struct {
+	// Queues store-and-forward queue of every franchise by name.
+	Queues map[string]saf.IQueue
+}
+
+// SAFItems lists store-and-forward items stuck after max retries by franchise, every item if all=true.
+func (as *AdminService) SAFItems(c *gin.Context) {
+	count := 0
+	byFranchise := make(map[string][]saf.Item, len(as.Queues))
+	for name, queue := range as.Queues {
+		items := queue.Items(c.Query("all") != "true")
+		for i := range items {
+			items[i].Message.F2 = utils.MaskPAN(items[i].Message.F2)
+		}
+		byFranchise[name] = items
+		count += len(items)
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count": count,
+		"items": byFranchise,
+	})
+}
@@ -2,27 +2,77 @@ package service
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"megalink/gateway/client/breaker"
 	"megalink/gateway/client/channels"
 	"megalink/gateway/client/connection"
+	"megalink/gateway/client/correlation"
+	"megalink/gateway/client/inflight"
+	"megalink/gateway/client/orphan"
+	"megalink/gateway/client/routing"
+	"megalink/gateway/client/sender"
+	"megalink/gateway/client/sequence"
+	"megalink/gateway/client/stip"
 	"megalink/gateway/client/types"
 	"megalink/gateway/client/utils"
 	"megalink/gateway/logger"
 	"megalink/gateway/shared"
 	"net/http"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+const (
+	// ResponseIssuerUnavailable F39 of the transactions declined while the franchise is degraded.
+	ResponseIssuerUnavailable = "91"
+)
+
+// processingCodes F3 of every transaction type accepted from the clients, goods and services from the default account.
+var processingCodes = map[string]string{
+	"0100": "000000",
+	"0200": "000000",
+}
+
 type Service struct {
-	Connection connection.IConnManager
-	Logger     logger.IFastLogger
-	Channel    *channels.ChannelStruct[*shared.Transaction]
+	// Connections franchise links by name.
+	Connections *connection.Registry
+	// Routes picks the franchise of a card.
+	Routes  routing.IRoutingTable
+	Logger  logger.IFastLogger
+	Pending *channels.Registry[*shared.Transaction]
+	// Senders write transactions through the sender chain of their franchise, by franchise name.
+	Senders        map[string]sender.SendFunc
+	EnvVars        *types.EnvVars
+	STAN           sequence.ISTANGenerator
+	CorrelationKey correlation.KeyFunc
+	// Limiters cap the transactions waiting for every franchise, by franchise name.
+	Limiters map[string]inflight.ILimiter
+	// Orphans reverses transactions answered as TIMEOUT and matches their late responses.
+	Orphans orphan.IOrphanHandler
+	// Breakers decline the transactions of a degraded franchise, by franchise name.
+	Breakers map[string]breaker.IBreaker
+	// StandIn approves low value transactions while their franchise is unavailable, never if nil.
+	StandIn stip.IStandIn
+	// drainMtx orders new transactions against Drain.
+	drainMtx sync.Mutex
+	draining bool
+	inFlight sync.WaitGroup
 }
 
 func (sv *Service) TransactionService(c *gin.Context) {
+	if !sv.begin() {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "El servicio se está deteniendo",
+		})
+		return
+	}
+	defer sv.inFlight.Done()
+
 	var requestBody types.ClientRequest
 	if err := c.ShouldBindJSON(&requestBody); err != nil {
 		sv.Logger.Error("Error al decodificar el body", err)
@@ -40,7 +90,46 @@ func (sv *Service) TransactionService(c *gin.Context) {
 		return
 	}
 
-	res, err := sv.sendMessage(sv.getTransactionRequest(&requestBody))
+	req, err := sv.getTransactionRequest(&requestBody)
+	if err != nil {
+		sv.Logger.Error("TransactionService", err)
+		c.JSON(http.StatusInternalServerError, gin.H{
+			"error": "Error interno del servidor",
+		})
+		return
+	}
+
+	res, err := sv.sendMessage(req)
+	if errors.Is(err, routing.ErrNoRoute) {
+		sv.Logger.Error("TransactionService", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Tarjeta no soportada",
+		})
+		return
+	}
+	if errors.Is(err, inflight.ErrSaturated) {
+		sv.Logger.Warning("TransactionService", err)
+		c.Header("Retry-After", strconv.Itoa(sv.EnvVars.InFlightRetryAfterSeconds))
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Demasiadas transacciones en curso, reintente más tarde",
+		})
+		return
+	}
+	if errors.Is(err, sender.ErrPacking) {
+		sv.Logger.Error("TransactionService", err)
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": "Transacción inválida: " + err.Error(),
+		})
+		return
+	}
+	if errors.Is(err, connection.ErrUnavailable) || errors.Is(err, connection.ErrNoConnection) ||
+		errors.Is(err, sender.ErrWriting) {
+		sv.Logger.Warning("TransactionService", err)
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": "Franquicia no disponible, reintente más tarde",
+		})
+		return
+	}
 	if err != nil {
 		sv.Logger.Error("TransactionService", err)
 		c.JSON(http.StatusInternalServerError, gin.H{
@@ -54,15 +143,59 @@ func (sv *Service) TransactionService(c *gin.Context) {
 	c.JSON(http.StatusOK, res)
 }
 
-func (sv *Service) getTransactionRequest(requestBody *types.ClientRequest) *shared.Transaction {
-	return &shared.Transaction{
+// Drain stops accepting transactions and waits until the in-flight ones are answered, reversed or ctx is done.
+func (sv *Service) Drain(ctx context.Context) error {
+	sv.drainMtx.Lock()
+	sv.draining = true
+	sv.drainMtx.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sv.inFlight.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// begin registers a new in-flight transaction, false once draining.
+func (sv *Service) begin() bool {
+	sv.drainMtx.Lock()
+	defer sv.drainMtx.Unlock()
+	if sv.draining {
+		return false
+	}
+	sv.inFlight.Add(1)
+	return true
+}
+
+func (sv *Service) getTransactionRequest(requestBody *types.ClientRequest) (*shared.Transaction, error) {
+	now := time.Now()
+	stan, err := sv.STAN.Next(sv.EnvVars.TerminalID)
+	if err != nil {
+		return nil, fmt.Errorf("getting stan: %w", err)
+	}
+	tx := &shared.Transaction{
 		MTI: requestBody.TransactionType,
 		F2:  requestBody.Card.Number,
-		F3:  fmt.Sprintf("%s%s", requestBody.Card.ExpiryYear, requestBody.Card.ExpiryMonth),
+		F3:  processingCodes[requestBody.TransactionType],
 		F4:  requestBody.Amount,
+		F14: requestBody.Card.ExpiryYear + requestBody.Card.ExpiryMonth,
 		F12: utils.GetTimeField(requestBody.Timezone),
 		F13: utils.GetDateField(requestBody.Timezone),
+		F32: sv.EnvVars.AcquirerID,
+		F37: sequence.RRN(now, stan),
+		F41: sv.EnvVars.TerminalID,
+		F42: sv.EnvVars.MerchantID,
 	}
+	tx.SetSTAN(stan)
+	tx.SetTransmissionDateTime(now)
+	return tx, nil
 }
 
 func (sv *Service) validateRequest(requestBody *types.ClientRequest) error {
@@ -75,35 +208,147 @@ func (sv *Service) validateRequest(requestBody *types.ClientRequest) error {
 	if requestBody.Card.Number == "" {
 		return fmt.Errorf("card no proporcionado")
 	}
+	// F4 is n12, an amount of zero is not a transaction.
+	if !isNumeric(requestBody.Amount, 1, 12) || strings.Trim(requestBody.Amount, "0") == "" {
+		return fmt.Errorf("amount inválido: %q", requestBody.Amount)
+	}
+	if _, ok := processingCodes[requestBody.TransactionType]; !ok {
+		return fmt.Errorf("transaction_type inválido: %q", requestBody.TransactionType)
+	}
+	if !isNumeric(requestBody.Card.ExpiryYear, 2, 2) {
+		return fmt.Errorf("expiry_year inválido: %q", requestBody.Card.ExpiryYear)
+	}
+	if month := requestBody.Card.ExpiryMonth; !isNumeric(month, 2, 2) || month < "01" || month > "12" {
+		return fmt.Errorf("expiry_month inválido: %q", month)
+	}
 
 	return nil
 }
 
+// isNumeric tells if s has between min and max digits and nothing else.
+func isNumeric(s string, min, max int) bool {
+	if len(s) < min || len(s) > max {
+		return false
+	}
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
 func (sv *Service) sendMessage(req *shared.Transaction) (*shared.Transaction, error) {
-	idChannel := fmt.Sprintf("%s%s", req.F12, req.F13)
-	ch := sv.Channel.Init(idChannel)
-	//TODO: change this time to env var
-	const timeOutChannel = 20 * time.Second
-	ctxTimeOut, cancel := context.WithTimeout(context.Background(), timeOutChannel)
-	defer func() {
-		close(ch)
-		sv.Channel.Delete(idChannel)
-		cancel()
-	}()
+	name, err := sv.Routes.Route(req.F2)
+	if err != nil {
+		return nil, err
+	}
+	pool, err := sv.Connections.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	// no wait for a response which cannot come while the franchise reconnects.
+	if state := pool.State(); state != connection.StateReady {
+		if res, ok := sv.standIn(req); ok {
+			return res, nil
+		}
+		return nil, fmt.Errorf("%w: %s is %s", connection.ErrUnavailable, name, state)
+	}
 
-	requestBytes, _ := json.Marshal(req)
-	_, err := sv.Connection.Write(requestBytes)
+	// nor while it is degraded, but a few probes tell when it recovers.
+	outcome, err := sv.Breakers[name].Allow()
+	if errors.Is(err, breaker.ErrOpen) {
+		if res, ok := sv.standIn(req); ok {
+			return res, nil
+		}
+		sv.Logger.Warning("Service", fmt.Sprintf("%s: %v, declining", name, err))
+		return decline(req, ResponseIssuerUnavailable), nil
+	}
 	if err != nil {
-		sv.Logger.Error("Error sending transaction", err)
+		return nil, err
 	}
+	// only a response or a timeout of the franchise counts, saturation or a send error is Aborted.
+	result := breaker.Aborted
+	defer func() { outcome(result) }()
 
-	select {
-	case response := <-ch:
-		re := response.Resp
-		sv.Logger.Info("Service response", re)
-		return re, nil
+	// the franchise window is shared by every transaction to it.
+	limiter := sv.Limiters[name]
+	if err := limiter.Acquire(context.Background()); err != nil {
+		return nil, err
+	}
+	defer limiter.Release()
+
+	// the link counts the transaction until its response arrives or it times out.
+	conn, done, err := pool.Pick()
+	if err != nil {
+		return nil, err
+	}
+	defer done()
 
-	case <-ctxTimeOut.Done():
+	pending, err := sv.Pending.Register(sv.CorrelationKey(req))
+	if err != nil {
+		return nil, fmt.Errorf("registering transaction: %w", err)
+	}
+	defer pending.Cancel()
+
+	// nothing reached the franchise, there is no response to wait for nor anything to reverse.
+	if err := sv.Senders[name](conn, req); err != nil {
+		return nil, err
+	}
+
+	ctxTimeOut, cancel := context.WithTimeout(
+		context.Background(), time.Duration(sv.EnvVars.ResponseWaitSeconds)*time.Second,
+	)
+	defer cancel()
+
+	re, err := pending.Wait(ctxTimeOut)
+	if errors.Is(err, context.DeadlineExceeded) {
+		// a response resolved right at the deadline is not lost, a later one goes to the orphan handler.
+		if late, ok := pending.Abandon(); ok {
+			re, err = late, nil
+		}
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		result = breaker.Failure
+		// the host may have approved it, reverse so the customer is never charged for a TIMEOUT.
+		sv.Orphans.TimedOut(req)
 		return &shared.Transaction{F39: "TIMEOUT"}, nil
 	}
+	if err != nil {
+		return nil, err
+	}
+
+	result = breaker.Success
+	for _, code := range sv.EnvVars.BreakerFailureCodes {
+		if re.F39 == code {
+			result = breaker.Failure
+		}
+	}
+
+	sv.Logger.Info("Service response", re)
+	return re, nil
+}
+
+// standIn approves req locally when stand-in is enabled and its limits allow it.
+func (sv *Service) standIn(req *shared.Transaction) (*shared.Transaction, bool) {
+	if sv.StandIn == nil {
+		return nil, false
+	}
+	res, err := sv.StandIn.Authorize(req)
+	if err != nil {
+		sv.Logger.Warning("Service", err)
+		return nil, false
+	}
+	return res, true
+}
+
+// decline answers req with code without reaching the franchise, only the fields identifying it go back.
+func decline(req *shared.Transaction, code string) *shared.Transaction {
+	return &shared.Transaction{
+		MTI: shared.ResponseMTI(req.MTI),
+		F11: req.F11,
+		F37: req.F37,
+		F39: code,
+		F41: req.F41,
+	}
 }
@@ -1,54 +1,147 @@
 package sign
 
 import (
-	"encoding/json"
+	"fmt"
 	"io"
+	"megalink/gateway/client/handler"
+	"megalink/gateway/client/network"
+	"megalink/gateway/client/sender"
 	"megalink/gateway/client/types"
 	"megalink/gateway/shared"
+	"sync"
+	"time"
 )
 
 type (
 	// ISignService is the interface of the Sign service.
 	ISignService interface {
+		// SendSignOn signs on with the franchise and waits for an approved 0810.
 		SendSignOn(writer io.Writer) error
+		// SendSignOff signs off from the franchise and waits for an approved 0810.
+		SendSignOff(writer io.Writer) error
+		// SendKeyChange requests a new working key and waits for an approved 0810.
+		SendKeyChange(writer io.Writer) error
+		// WorkingKey gets the last working key received from the franchise.
+		WorkingKey() string
+		// SetWorkingKey keeps a working key pushed by the franchise.
+		SetWorkingKey(key string)
+		// HandleSignResponse delivers sign on, sign off and key change responses to the waiting request.
+		HandleSignResponse(next handler.MessageHandlerFunc) handler.MessageHandlerFunc
 	}
 
 	// SignService manage sending SignOn and SignOff messages to the franchise.
 	SignService struct {
 		EnvVars *types.EnvVars
+		// Send writes requests through the sender chain.
+		Send sender.SendFunc
+		// WaitResponseTime time to wait for the 0810.
+		WaitResponseTime time.Duration
+		signResponse     chan *shared.Transaction
+		// mtx allows a single network management request in flight.
+		mtx        sync.Mutex
+		keyMtx     sync.RWMutex
+		workingKey string
 	}
 )
 
 // NewSignService is the provider for new SignService.
-func NewSignService(conf *types.EnvVars) ISignService {
+func NewSignService(conf *types.EnvVars, send sender.SendFunc) ISignService {
 	return &SignService{
-		EnvVars: conf,
+		EnvVars:          conf,
+		Send:             send,
+		WaitResponseTime: time.Duration(conf.SignOnResponseWaitSeconds) * time.Second,
+		signResponse:     make(chan *shared.Transaction, 1),
 	}
 }
 
-// SendSignOn sends a SignOn request to the franchise.
+// SendSignOn sends a 0800 sign on to the franchise.
 func (sh *SignService) SendSignOn(writer io.Writer) error {
-	signOnData := &shared.Transaction{
-		MTI: "0200",
-		F12: "",
-		F13: "",
-	}
-	return sh.sendMessage(signOnData, writer)
+	_, err := sh.exchange(network.CodeSignOn, writer)
+	return err
+}
+
+// SendSignOff sends a 0800 sign off to the franchise.
+func (sh *SignService) SendSignOff(writer io.Writer) error {
+	_, err := sh.exchange(network.CodeSignOff, writer)
+	return err
 }
 
-func (sh *SignService) sendMessage(
-	signData *shared.Transaction,
-	writer io.Writer,
-) error {
-	// Encode heartbeat request to JSON
-	requestBytes, err := json.Marshal(signData)
+// SendKeyChange sends a 0800 key change and keeps the working key received in F48.
+func (sh *SignService) SendKeyChange(writer io.Writer) error {
+	res, err := sh.exchange(network.CodeKeyChange, writer)
 	if err != nil {
 		return err
 	}
-
-	if _, err := writer.Write(requestBytes); err != nil {
-		return err
+	if res.F48 == "" {
+		return fmt.Errorf("%w: F70 %s without working key", network.ErrRejected, network.CodeKeyChange)
 	}
 
+	sh.SetWorkingKey(res.F48)
 	return nil
 }
+
+// SetWorkingKey keeps a working key pushed by the franchise.
+func (sh *SignService) SetWorkingKey(key string) {
+	sh.keyMtx.Lock()
+	defer sh.keyMtx.Unlock()
+	sh.workingKey = key
+}
+
+// WorkingKey gets the last working key received from the franchise.
+func (sh *SignService) WorkingKey() string {
+	sh.keyMtx.RLock()
+	defer sh.keyMtx.RUnlock()
+	return sh.workingKey
+}
+
+// HandleSignResponse delivers sign on, sign off and key change responses to the waiting request.
+func (sh *SignService) HandleSignResponse(next handler.MessageHandlerFunc) handler.MessageHandlerFunc {
+	return func(writer io.ReadWriter, response *shared.Transaction) error {
+		switch response.F70 {
+		case network.CodeSignOn, network.CodeSignOff, network.CodeKeyChange:
+		default:
+			return next(writer, response)
+		}
+
+		// nobody waits for a late response, drop it.
+		select {
+		case sh.signResponse <- response:
+		default:
+			fmt.Printf("\nHandleSignResponse | unexpected F70 %s STAN %s", response.F70, response.F11)
+		}
+		return nil
+	}
+}
+
+// exchange sends a 0800 with code and waits for the matching 0810.
+func (sh *SignService) exchange(code string, writer io.Writer) (*shared.Transaction, error) {
+	sh.mtx.Lock()
+	defer sh.mtx.Unlock()
+
+	req := network.NewRequest(code)
+
+	// discard a response left behind by a previous request.
+	select {
+	case <-sh.signResponse:
+	default:
+	}
+
+	// the sender chain sets the STAN the response is matched on.
+	if err := sh.Send(writer, req); err != nil {
+		return nil, err
+	}
+
+	timer := time.NewTimer(sh.WaitResponseTime)
+	defer timer.Stop()
+	for {
+		select {
+		case res := <-sh.signResponse:
+			if res.F11 != req.F11 || res.F70 != req.F70 {
+				continue
+			}
+			return res, network.Validate(req, res)
+		case <-timer.C:
+			return nil, network.Validate(req, nil)
+		}
+	}
+}
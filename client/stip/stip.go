@@ -0,0 +1,220 @@
+// Package stip approves transactions on behalf of the issuer while its franchise is unavailable.
+package stip
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"megalink/gateway/client/metrics"
+	"megalink/gateway/client/routing"
+	"megalink/gateway/client/saf"
+	"megalink/gateway/client/sequence"
+	"megalink/gateway/client/utils"
+	"megalink/gateway/logger"
+	"megalink/gateway/shared"
+	"sync"
+	"time"
+)
+
+const (
+	// MTIAdvice advice telling the franchise about an authorisation approved in stand-in.
+	MTIAdvice = "0220"
+	// ResponseApproved F39 of the transactions approved in stand-in.
+	ResponseApproved = "00"
+
+	stipTag = "StandIn | %s"
+)
+
+var (
+	// ErrNotAllowed triggered when a transaction cannot be approved in stand-in.
+	ErrNotAllowed = errors.New("not allowed in stand-in")
+)
+
+type (
+	// IStandIn approves transactions locally.
+	IStandIn interface {
+		// Authorize approves req within the stand-in limits and queues its advice, ErrNotAllowed otherwise.
+		Authorize(req *shared.Transaction) (*shared.Transaction, error)
+	}
+
+	// StandIn implements IStandIn, the approved amounts are kept in memory and start over every business day.
+	StandIn struct {
+		// MaxAmount largest amount of a transaction approved in stand-in.
+		MaxAmount int64
+		// CardLimit total amount approved in stand-in for a card, unlimited if 0.
+		CardLimit int64
+		// MerchantLimit total amount approved in stand-in for a merchant, unlimited if 0.
+		MerchantLimit int64
+		// CutoverHour local hour at which a new business day starts, as for the sequences.
+		CutoverHour int
+		// Queues store-and-forward queue of every franchise by name.
+		Queues    map[string]saf.IQueue
+		Routes    routing.IRoutingTable
+		Metrics   metrics.IMetrics
+		Logger    logger.IFastLogger
+		mtx       sync.Mutex
+		day       string
+		cards     map[string]int64
+		merchants map[string]int64
+	}
+)
+
+// NewStandIn provides a new StandIn.
+func NewStandIn(
+	maxAmount int64,
+	cardLimit int64,
+	merchantLimit int64,
+	cutoverHour int,
+	queues map[string]saf.IQueue,
+	routes routing.IRoutingTable,
+	m metrics.IMetrics,
+	logger logger.IFastLogger,
+) IStandIn {
+	return &StandIn{
+		MaxAmount:     maxAmount,
+		CardLimit:     cardLimit,
+		MerchantLimit: merchantLimit,
+		CutoverHour:   cutoverHour,
+		Queues:        queues,
+		Routes:        routes,
+		Metrics:       m,
+		Logger:        logger,
+		cards:         make(map[string]int64),
+		merchants:     make(map[string]int64),
+	}
+}
+
+// Authorize approves req within the stand-in limits and queues its advice, ErrNotAllowed otherwise.
+// The response carries a local authorisation code in F38, starting with S.
+func (si *StandIn) Authorize(req *shared.Transaction) (*shared.Transaction, error) {
+	tag := fmt.Sprintf(stipTag, "Authorize")
+
+	// only authorisations and purchases, a reversal must reach the franchise.
+	if req.MTI != "0100" && req.MTI != "0200" {
+		return nil, fmt.Errorf("%w: MTI %s", ErrNotAllowed, req.MTI)
+	}
+	amount, err := req.Amount()
+	if err != nil || !isAmount(req.F4) || amount <= 0 {
+		return nil, fmt.Errorf("%w: amount %q", ErrNotAllowed, req.F4)
+	}
+	name, err := si.Routes.Route(req.F2)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotAllowed, err)
+	}
+	queue, ok := si.Queues[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: no queue for franchise %q", ErrNotAllowed, name)
+	}
+
+	authCode, err := authorisationCode()
+	if err != nil {
+		return nil, err
+	}
+
+	card := cardKey(req.F2)
+	if err := si.reserve(card, req.F42, amount); err != nil {
+		si.Metrics.Inc("stip.declined." + name)
+		return nil, err
+	}
+
+	response := withoutCardData(*req)
+	response.MTI = shared.ResponseMTI(req.MTI)
+	response.F38 = authCode
+	response.F39 = ResponseApproved
+
+	// the franchise learns about the approval once it is back, the queue is persisted.
+	advice := withoutAuthenticationData(*req)
+	advice.MTI = MTIAdvice
+	advice.F38 = authCode
+	advice.F39 = ResponseApproved
+	if err := queue.Enqueue(&advice); err != nil {
+		si.release(card, req.F42, amount)
+		return nil, fmt.Errorf("queuing advice of STAN %s: %w", req.F11, err)
+	}
+
+	si.Metrics.Inc("stip.approved." + name)
+	si.Logger.Info(tag, fmt.Sprintf("%s STAN %s approved in stand-in with %s", name, req.F11, authCode))
+	return &response, nil
+}
+
+// reserve adds amount to the totals of card and merchant if it fits in the limits.
+func (si *StandIn) reserve(card, merchant string, amount int64) error {
+	si.mtx.Lock()
+	defer si.mtx.Unlock()
+
+	if day := sequence.BusinessDate(time.Now(), si.CutoverHour); day != si.day {
+		si.day = day
+		si.cards = make(map[string]int64)
+		si.merchants = make(map[string]int64)
+	}
+
+	switch {
+	case amount > si.MaxAmount:
+		return fmt.Errorf("%w: amount %d over %d", ErrNotAllowed, amount, si.MaxAmount)
+	case si.CardLimit > 0 && si.cards[card]+amount > si.CardLimit:
+		return fmt.Errorf("%w: card limit %d reached", ErrNotAllowed, si.CardLimit)
+	case si.MerchantLimit > 0 && si.merchants[merchant]+amount > si.MerchantLimit:
+		return fmt.Errorf("%w: merchant %s limit %d reached", ErrNotAllowed, merchant, si.MerchantLimit)
+	}
+
+	si.cards[card] += amount
+	si.merchants[merchant] += amount
+	return nil
+}
+
+// release takes back an amount reserved for a transaction which was not approved.
+func (si *StandIn) release(card, merchant string, amount int64) {
+	si.mtx.Lock()
+	defer si.mtx.Unlock()
+	si.cards[card] -= amount
+	si.merchants[merchant] -= amount
+}
+
+// authorisationCode gets a local F38, S and 5 random digits which must not be guessable.
+func authorisationCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(100000))
+	if err != nil {
+		return "", fmt.Errorf("authorisation code: %w", err)
+	}
+	return fmt.Sprintf("S%05d", n.Int64()), nil
+}
+
+// cardKey identifies a card without keeping its PAN in memory.
+func cardKey(pan string) string {
+	sum := sha256.Sum256([]byte(pan))
+	return hex.EncodeToString(sum[:])
+}
+
+// isAmount tells if f4 is an n12 amount, up to 12 digits and nothing else.
+func isAmount(f4 string) bool {
+	if f4 == "" || len(f4) > 12 {
+		return false
+	}
+	for _, r := range f4 {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// withoutCardData keeps only the masked PAN of the card, the response goes back to the caller.
+func withoutCardData(tx shared.Transaction) shared.Transaction {
+	tx = withoutAuthenticationData(tx)
+	tx.F2 = utils.MaskPAN(tx.F2)
+	tx.F14 = ""
+	return tx
+}
+
+// withoutAuthenticationData drops the tracks, PIN block and chip data, never kept after the authorisation.
+func withoutAuthenticationData(tx shared.Transaction) shared.Transaction {
+	tx.F35 = ""
+	tx.F36 = ""
+	tx.F45 = ""
+	tx.F52 = ""
+	tx.F55 = ""
+	return tx
+}
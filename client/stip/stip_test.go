@@ -0,0 +1,158 @@
+package stip
+
+import (
+	"context"
+	"errors"
+	"megalink/gateway/client/metrics"
+	"megalink/gateway/client/routing"
+	"megalink/gateway/client/saf"
+	"megalink/gateway/shared"
+	"regexp"
+	"testing"
+)
+
+// nopLogger discards the stand-in logs.
+type nopLogger struct{}
+
+func (nopLogger) Debug(string, interface{})   {}
+func (nopLogger) Info(string, interface{})    {}
+func (nopLogger) Warning(string, interface{}) {}
+func (nopLogger) Error(string, interface{})   {}
+func (nopLogger) WithPrefix(string)           {}
+
+// queue keeps the advices queued.
+type queue struct {
+	advices []*shared.Transaction
+	err     error
+}
+
+func (q *queue) Enqueue(tx *shared.Transaction) error {
+	if q.err != nil {
+		return q.err
+	}
+	q.advices = append(q.advices, tx)
+	return nil
+}
+func (q *queue) Drain(context.Context) {}
+func (q *queue) Items(bool) []saf.Item { return nil }
+
+// route sends every card to visa but the ones starting with 9.
+type route struct{}
+
+func (route) Route(pan string) (string, error) {
+	if pan != "" && pan[0] == '9' {
+		return "", routing.ErrNoRoute
+	}
+	return "visa", nil
+}
+
+func newTestStandIn(q *queue, maxAmount, cardLimit, merchantLimit int64) IStandIn {
+	return NewStandIn(
+		maxAmount, cardLimit, merchantLimit, 0, map[string]saf.IQueue{"visa": q}, route{}, metrics.NewMetrics(), nopLogger{},
+	)
+}
+
+func purchase(pan, amount, merchant string) *shared.Transaction {
+	return &shared.Transaction{
+		MTI: "0200", F2: pan, F4: amount, F11: "000001", F14: "2812", F35: pan + "=2812",
+		F36: "track 3", F42: merchant, F45: "track 1", F52: "0102030405060708", F55: "9F2608",
+	}
+}
+
+func TestAuthorizeApprovesAndQueuesAdvice(t *testing.T) {
+	q := &queue{}
+	si := newTestStandIn(q, 10000, 0, 0)
+
+	res, err := si.Authorize(purchase("4111111111111111", "000000001000", "M1"))
+	if err != nil {
+		t.Fatalf("Authorize: %v", err)
+	}
+	if res.MTI != "0210" || res.F39 != ResponseApproved || !regexp.MustCompile(`^S\d{5}$`).MatchString(res.F38) {
+		t.Errorf("response MTI %s F38 %q F39 %s, want an approved 0210 with a local code", res.MTI, res.F38, res.F39)
+	}
+	if res.F2 != "411111******1111" || res.F14 != "" || res.F35 != "" || res.F52 != "" || res.F55 != "" {
+		t.Errorf("response carries card data: %+v", res)
+	}
+
+	if len(q.advices) != 1 {
+		t.Fatalf("%d advices queued, want 1", len(q.advices))
+	}
+	advice := q.advices[0]
+	if advice.MTI != MTIAdvice || advice.F38 != res.F38 || advice.F39 != ResponseApproved {
+		t.Errorf("advice MTI %s F38 %q F39 %s, want a 0220 with the response code", advice.MTI, advice.F38, advice.F39)
+	}
+	if advice.F2 != "4111111111111111" || advice.F14 != "2812" {
+		t.Errorf("advice F2 %q F14 %q, want the card the franchise settles", advice.F2, advice.F14)
+	}
+	if advice.F35 != "" || advice.F36 != "" || advice.F45 != "" || advice.F52 != "" || advice.F55 != "" {
+		t.Errorf("advice keeps track, PIN or chip data: %+v", advice)
+	}
+}
+
+func TestAuthorizeNotAllowed(t *testing.T) {
+	tests := []struct {
+		name string
+		tx   *shared.Transaction
+	}{
+		{name: "reversal", tx: &shared.Transaction{MTI: "0400", F2: "4111111111111111", F4: "000000001000"}},
+		{name: "over max amount", tx: purchase("4111111111111111", "000000010001", "M1")},
+		{name: "zero amount", tx: purchase("4111111111111111", "000000000000", "M1")},
+		{name: "negative amount", tx: purchase("4111111111111111", "-00000001000", "M1")},
+		{name: "not numeric amount", tx: purchase("4111111111111111", "00000000100A", "M1")},
+		{name: "no route", tx: purchase("9111111111111111", "000000001000", "M1")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			q := &queue{}
+			if _, err := newTestStandIn(q, 10000, 0, 0).Authorize(tt.tx); !errors.Is(err, ErrNotAllowed) {
+				t.Errorf("Authorize error = %v, want ErrNotAllowed", err)
+			}
+			if len(q.advices) != 0 {
+				t.Errorf("%d advices queued, want none", len(q.advices))
+			}
+		})
+	}
+}
+
+func TestAuthorizeLimits(t *testing.T) {
+	si := newTestStandIn(&queue{}, 10000, 2500, 4000)
+	steps := []struct {
+		tx      *shared.Transaction
+		allowed bool
+	}{
+		{tx: purchase("4111111111111111", "000000001000", "M1"), allowed: true},
+		{tx: purchase("4111111111111111", "000000001000", "M1"), allowed: true},
+		// card limit reached.
+		{tx: purchase("4111111111111111", "000000001000", "M1"), allowed: false},
+		{tx: purchase("4222222222222222", "000000001000", "M1"), allowed: true},
+		// merchant limit reached.
+		{tx: purchase("4333333333333333", "000000001500", "M1"), allowed: false},
+		{tx: purchase("4333333333333333", "000000001500", "M2"), allowed: true},
+	}
+
+	for i, step := range steps {
+		_, err := si.Authorize(step.tx)
+		if step.allowed && err != nil {
+			t.Errorf("step #%d: Authorize: %v", i, err)
+		}
+		if !step.allowed && !errors.Is(err, ErrNotAllowed) {
+			t.Errorf("step #%d: Authorize error = %v, want ErrNotAllowed", i, err)
+		}
+	}
+}
+
+func TestAuthorizeReleasesWhenAdviceIsNotQueued(t *testing.T) {
+	q := &queue{err: errors.New("disk full")}
+	si := newTestStandIn(q, 10000, 1000, 0)
+
+	if _, err := si.Authorize(purchase("4111111111111111", "000000001000", "M1")); err == nil {
+		t.Fatal("Authorize without advice succeeded, want error")
+	}
+
+	// the amount was not approved, so it does not count against the card.
+	q.err = nil
+	if _, err := si.Authorize(purchase("4111111111111111", "000000001000", "M1")); err != nil {
+		t.Errorf("Authorize after the failed one: %v", err)
+	}
+}
@@ -16,9 +16,107 @@ type Card struct {
 
 type EnvVars struct {
 	GinServerAdress              string
-	FranchiseConnectionAdress    string
 	ShowEcho                     bool
 	ShowHeartBeat                bool
 	HeartSendBeatIntervalSeconds int
 	HeartBeatResponseWaitSeconds int
+	// Franchises hosts the gateway connects to.
+	Franchises []Franchise
+	// RoutingTablePath YAML or JSON BIN routing table picking the franchise of a card.
+	RoutingTablePath string
+	// ResponseWaitSeconds time to wait for the response of a transaction before answering TIMEOUT and reversing it.
+	ResponseWaitSeconds int
+	// SignOnResponseWaitSeconds time to wait for the 0810 of a sign on, sign off or key change.
+	SignOnResponseWaitSeconds int
+	// KeyChangeIntervalMinutes time between working key changes requested on every link after the one of its
+	// sign on, none other if 0.
+	KeyChangeIntervalMinutes int
+	// ShutdownWaitSeconds time given to in-flight transactions and sign off on shutdown.
+	ShutdownWaitSeconds int
+	// MessageCodec wire format used with the franchise, see codec.NewCodec.
+	MessageCodec string
+	// FieldSpecPath YAML or JSON file describing the franchise ISO 8583 fields, built in spec if empty.
+	FieldSpecPath string
+	// Framing length header used on the franchise stream, see framing.NewFramer.
+	Framing string
+	// TPDU hex header sent after the length header, none if empty.
+	TPDU string
+	// TerminalID F41 sent on every message.
+	TerminalID string
+	// MerchantID F42 sent on financial messages.
+	MerchantID string
+	// AcquirerID F32 sent on financial messages.
+	AcquirerID string
+	// CorrelationFields data elements matching a response with its request, see correlation.DefaultFields.
+	CorrelationFields []int
+	// SequenceStorePath JSON file keeping STANs across restarts, in memory only if empty.
+	SequenceStorePath string
+	// SequenceCutoverHour local hour at which a new business day starts and STANs go back to 1.
+	SequenceCutoverHour int
+	// SequenceReserveBlock STANs reserved on every write of the sequence store, skipped after a restart.
+	SequenceReserveBlock int
+	// SAFMaxRetries retransmissions before an item is marked as stuck, unlimited if 0.
+	SAFMaxRetries int
+	// SAFResponseWaitSeconds time to wait for an acknowledgement before retransmitting.
+	SAFResponseWaitSeconds int
+	// SAFEncryptionKey hex AES key sealing the persisted SAF messages, required by every franchise with a SAFStorePath.
+	SAFEncryptionKey string
+	// MaxInFlight transactions waiting for every franchise at once, unlimited if 0.
+	MaxInFlight int
+	// InFlightQueueSize transactions allowed to wait for a free slot, unlimited if 0.
+	InFlightQueueSize int
+	// InFlightQueueTimeoutSeconds longest wait for a free slot before answering 503.
+	InFlightQueueTimeoutSeconds int
+	// InFlightRetryAfterSeconds Retry-After sent with the 503.
+	InFlightRetryAfterSeconds int
+	// OrphanJournalPath JSON lines file recording responses nobody waits for, not recorded if empty.
+	OrphanJournalPath string
+	// LateResponseRetentionSeconds time a transaction answered as TIMEOUT is matched against late responses.
+	LateResponseRetentionSeconds int
+	// PrimaryProbeIntervalSeconds time between probes of the primary host while on a backup one, never probed if 0.
+	PrimaryProbeIntervalSeconds int
+	// FailbackHealthyProbes probes in a row the primary host must answer before failing back.
+	FailbackHealthyProbes int
+	// FailbackDrainSeconds longest wait for the requests of a link before moving it back to the primary host.
+	FailbackDrainSeconds int
+	// ReconnectBackoffInitialSeconds wait after the first failed reconnect, doubled on every failure.
+	ReconnectBackoffInitialSeconds int
+	// ReconnectBackoffMaxSeconds longest wait between reconnect attempts.
+	ReconnectBackoffMaxSeconds int
+	// BreakerFailureThreshold consecutive timeouts or system errors declining the transactions of a franchise, never if 0.
+	BreakerFailureThreshold int
+	// BreakerOpenSeconds time transactions are declined before probing the franchise again.
+	BreakerOpenSeconds int
+	// BreakerHalfOpenProbes transactions sent at once to probe a franchise which was degraded.
+	BreakerHalfOpenProbes int
+	// BreakerFailureCodes F39 response codes telling the franchise is degraded, like 91 and 96.
+	BreakerFailureCodes []string
+	// StandInMaxAmount largest amount approved by the gateway while the franchise is unavailable, no stand-in if 0.
+	StandInMaxAmount int64
+	// StandInCardLimit total amount approved in stand-in per card and day, unlimited if 0.
+	StandInCardLimit int64
+	// StandInMerchantLimit total amount approved in stand-in per merchant and day, unlimited if 0.
+	StandInMerchantLimit int64
+}
+
+// Franchise is a franchise host the gateway connects to.
+type Franchise struct {
+	// Name referenced by the routing table.
+	Name string
+	// Addresses host:port of the franchise hosts, the primary first and then its backups in failover order.
+	Addresses []string
+	// Connections parallel links with the franchise, one if 0.
+	Connections int
+	// Selection of the link for every write, see connection.SelectRoundRobin and connection.SelectLeastInFlight.
+	Selection string
+	// SAFStorePath JSON file keeping reversals and advices until acknowledged, in memory only if empty.
+	SAFStorePath string
+	// MessageCodec wire format used with the franchise, EnvVars.MessageCodec if empty.
+	MessageCodec string
+	// FieldSpecPath ISO 8583 fields of the franchise, EnvVars.FieldSpecPath if empty.
+	FieldSpecPath string
+	// Framing length header used with the franchise, EnvVars.Framing if empty.
+	Framing string
+	// TPDU hex header sent after the length header, EnvVars.TPDU if empty.
+	TPDU string
 }
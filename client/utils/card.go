@@ -0,0 +1,11 @@
+package utils
+
+import "strings"
+
+// MaskPAN keeps the BIN and the last 4 digits of a card number.
+func MaskPAN(pan string) string {
+	if len(pan) < 10 {
+		return strings.Repeat("*", len(pan))
+	}
+	return pan[:6] + strings.Repeat("*", len(pan)-10) + pan[len(pan)-4:]
+}
@@ -1,14 +1,18 @@
 package main
 
 import (
-	"encoding/binary"
-	"encoding/json"
+	"flag"
 	"fmt"
 	"github.com/google/uuid"
+	"io"
 	"log"
 	"math/rand"
 	"megalink/gateway/shared"
+	"megalink/gateway/shared/codec"
+	"megalink/gateway/shared/framing"
 	"net"
+	"strings"
+	"sync"
 	"time"
 )
 
@@ -17,16 +21,30 @@ func RandomZeroOrOne() int {
 	return rand.Intn(4)              // Generate a random number between 0 and 1 (inclusive)
 }
 
-func handleConnection(conn net.Conn, done chan struct{}) {
+func handleConnection(
+	conn net.Conn,
+	messageCodec codec.Codec,
+	framer framing.Framer,
+	delay time.Duration,
+	hostEcho time.Duration,
+	responseCode string,
+	done chan struct{},
+) {
 	defer conn.Close()
 	fmt.Println("Handle connection")
+	writeMtx := &sync.Mutex{}
+
+	if hostEcho > 0 {
+		stop := make(chan struct{})
+		defer close(stop)
+		go sendHostEcho(conn, writeMtx, messageCodec, framer, hostEcho, stop)
+	}
 
 	for {
-		// Read data from connection
-		data := make([]byte, 1024)
-		n, err := conn.Read(data)
+		// Read a whole frame from connection
+		data, err := framing.ReadFrame(framer, conn)
 		if err != nil {
-			if err.Error() == "EOF" {
+			if err == io.EOF {
 				fmt.Println("Client closed connection")
 				break
 			}
@@ -34,67 +52,158 @@ func handleConnection(conn net.Conn, done chan struct{}) {
 			return
 		}
 
-		// Decode request from JSON
-		var request shared.Transaction
-		err = json.Unmarshal(data[:n], &request)
+		// Decode request
+		request, err := messageCodec.Decode(data)
 		if err != nil {
 			fmt.Println("Error decoding request:", err)
 			return
 		}
 		fmt.Println("request:")
-		fmt.Println(request)
-
-		//time.Sleep(8 * time.Second)
+		fmt.Println(*request)
 
-		responses := []string{"00", "00", "00", "00"}
+		// answers to host initiated requests are not answered back.
+		if len(request.MTI) == 4 && (request.MTI[2]-'0')%2 == 1 {
+			continue
+		}
 
-		// Create server response
-		response := request
-		response.F39 = responses[RandomZeroOrOne()]
-		id, _ := uuid.NewV7()
-		response.F38 = id.String()[0:6]
+		// Slow financial responses run aside so echo and reversals keep flowing.
+		if delay > 0 && strings.HasPrefix(request.MTI, "02") {
+			go func() {
+				time.Sleep(delay)
+				if err := writeResponse(conn, writeMtx, messageCodec, framer, request, responseCode); err != nil {
+					fmt.Println(err)
+				}
+			}()
+			continue
+		}
 
-		// Encode response as JSON
-		responseData, err := json.Marshal(response)
-		if err != nil {
-			fmt.Println("Error encoding response:", err)
+		if err := writeResponse(conn, writeMtx, messageCodec, framer, request, responseCode); err != nil {
+			fmt.Println(err)
 			return
 		}
+	}
 
-		// Create a buffer to hold the length header and the response data
-		responseWithHeader := make([]byte, 4+len(responseData))
-
-		// Write the length of the response data into the first 4 bytes of the buffer
-		binary.BigEndian.PutUint32(responseWithHeader[:4], uint32(len(responseData)))
+	done <- struct{}{} // Signal completion through channel
+}
 
-		// Copy the response data into the buffer after the length header
-		copy(responseWithHeader[4:], responseData)
+// sendHostEcho sends a host initiated 0800 echo every interval until stop is closed.
+func sendHostEcho(
+	conn net.Conn,
+	writeMtx *sync.Mutex,
+	messageCodec codec.Codec,
+	framer framing.Framer,
+	interval time.Duration,
+	stop chan struct{},
+) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for stan := 900000; ; stan++ {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
 
-		// Write the response with the length header back to the connection
-		_, err = conn.Write(responseWithHeader)
-		if err != nil {
-			fmt.Println("Error writing response:", err)
+		request := &shared.Transaction{MTI: "0800", F70: "301"}
+		request.SetSTAN(stan)
+		request.SetTransmissionDateTime(time.Now())
+		if err := writeMessage(conn, writeMtx, messageCodec, framer, request); err != nil {
+			fmt.Println(err)
 			return
 		}
-		fmt.Println("response:")
-		fmt.Println(uint32(len(responseData)))
-		fmt.Println(string(responseWithHeader))
 	}
+}
 
-	done <- struct{}{} // Signal completion through channel
+func writeResponse(
+	conn net.Conn,
+	writeMtx *sync.Mutex,
+	messageCodec codec.Codec,
+	framer framing.Framer,
+	request *shared.Transaction,
+	responseCode string,
+) error {
+	responses := []string{"00", "00", "00", "00"}
+
+	// Create server response
+	response := *request
+	response.MTI = shared.ResponseMTI(request.MTI)
+	response.F39 = responses[RandomZeroOrOne()]
+	if responseCode != "" && strings.HasPrefix(request.MTI, "02") {
+		response.F39 = responseCode
+	}
+	id, _ := uuid.NewV7()
+	response.F38 = id.String()[0:6]
+	// key change responses carry a new working key.
+	if request.MTI == "0800" && request.F70 == "161" {
+		response.F48 = strings.ToUpper(strings.ReplaceAll(id.String(), "-", ""))
+	}
+
+	return writeMessage(conn, writeMtx, messageCodec, framer, &response)
+}
+
+// writeMessage encodes and frames message and writes it under writeMtx.
+func writeMessage(
+	conn net.Conn,
+	writeMtx *sync.Mutex,
+	messageCodec codec.Codec,
+	framer framing.Framer,
+	message *shared.Transaction,
+) error {
+	// Encode response
+	responseData, err := messageCodec.Encode(message)
+	if err != nil {
+		return fmt.Errorf("encoding response: %w", err)
+	}
+
+	// Prepend the length header to the response data
+	responseWithHeader, err := framer.Frame(responseData)
+	if err != nil {
+		return fmt.Errorf("framing response: %w", err)
+	}
+
+	// Write the response with the length header back to the connection
+	writeMtx.Lock()
+	defer writeMtx.Unlock()
+	_, err = conn.Write(responseWithHeader)
+	if err != nil {
+		return fmt.Errorf("writing response: %w", err)
+	}
+	fmt.Println("response:")
+	fmt.Println(uint32(len(responseData)))
+	fmt.Printf("%q\n", responseWithHeader)
+	return nil
 }
 
 func main() {
-	// Define server address for listening on port 9090
-	listenAddr := "localhost:9090"
+	// Define server address, backup hosts listen on another port.
+	listenAddr := flag.String("addr", "localhost:9090", "address the simulated franchise host listens on")
+	codecName := flag.String("codec", codec.ISO8583ASCII, "wire format shared with the gateway client")
+	specPath := flag.String("spec", "", "ISO 8583 field spec file, built in spec if empty")
+	framingKind := flag.String("framing", framing.Binary4, "length header shared with the gateway client")
+	tpdu := flag.String("tpdu", "", "hex TPDU sent after the length header, none if empty")
+	delay := flag.Duration("delay", 0, "wait before answering financial requests, to simulate timeouts")
+	hostEcho := flag.Duration("host-echo", 0, "interval of echo requests sent by the host, none if 0")
+	responseCode := flag.String("response-code", "", "F39 answered to financial requests, like 96 to simulate a degraded host")
+	flag.Parse()
+
+	messageCodec, err := codec.NewCodec(*codecName, *specPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	framer, err := framing.NewFramer(*framingKind, *tpdu)
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	listener, err := net.Listen("tcp", listenAddr)
+	listener, err := net.Listen("tcp", *listenAddr)
 	if err != nil {
 		log.Fatal(err) // Use log.Fatal for critical errors
 	}
 	defer listener.Close()
 
-	fmt.Println("Server listening on", listenAddr)
+	fmt.Println("Server listening on", *listenAddr)
 	done := make(chan struct{})
 	for {
 		conn, err := listener.Accept()
@@ -105,7 +214,7 @@ func main() {
 
 		fmt.Println("Connection accepted:", conn.RemoteAddr().String())
 		go func() {
-			handleConnection(conn, done)
+			handleConnection(conn, messageCodec, framer, *delay, *hostEcho, *responseCode, done)
 		}()
 		go func() {
 			<-done // Wait for signal from handleConnection
@@ -0,0 +1,144 @@
+package shared
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// transmissionLayout F7 layout, always in UTC.
+	transmissionLayout = "0102150405"
+	// originalDataLength F90 length: MTI, STAN, transmission date time, acquirer and forwarder IDs.
+	originalDataLength = 42
+)
+
+// OriginalData is the F90 content identifying the transaction a reversal or advice refers to.
+type OriginalData struct {
+	MTI                  string
+	STAN                 string
+	TransmissionDateTime string
+	AcquirerID           string
+	ForwarderID          string
+}
+
+// ResponseMTI turns a request MTI into its response MTI, 0200 into 0210, 0401 into 0410...
+// An MTI which is not a request is returned as is.
+func ResponseMTI(mti string) string {
+	if len(mti) != 4 || mti[2] < '0' || mti[2] > '8' || (mti[2]-'0')%2 != 0 {
+		return mti
+	}
+	return mti[:2] + string(mti[2]+1) + "0"
+}
+
+// STAN gets F11 system trace audit number.
+func (tx *Transaction) STAN() (int, error) {
+	return strconv.Atoi(tx.F11)
+}
+
+// SetSTAN sets F11 system trace audit number.
+func (tx *Transaction) SetSTAN(stan int) {
+	tx.F11 = fmt.Sprintf("%06d", stan)
+}
+
+// Amount gets F4 amount in minor units.
+func (tx *Transaction) Amount() (int64, error) {
+	return strconv.ParseInt(tx.F4, 10, 64)
+}
+
+// SetAmount sets F4 amount in minor units.
+func (tx *Transaction) SetAmount(amount int64) {
+	tx.F4 = fmt.Sprintf("%012d", amount)
+}
+
+// TransmissionDateTime gets F7 in UTC, the year is the one closest to now as F7 does not carry it.
+func (tx *Transaction) TransmissionDateTime() (time.Time, error) {
+	now := time.Now().UTC()
+	t, err := time.Parse("2006"+transmissionLayout, strconv.Itoa(now.Year())+tx.F7)
+	if err != nil {
+		return time.Time{}, err
+	}
+	switch {
+	case t.Sub(now) > 180*24*time.Hour:
+		t = t.AddDate(-1, 0, 0)
+	case now.Sub(t) > 180*24*time.Hour:
+		t = t.AddDate(1, 0, 0)
+	}
+	return t, nil
+}
+
+// SetTransmissionDateTime sets F7 from t in UTC.
+func (tx *Transaction) SetTransmissionDateTime(t time.Time) {
+	tx.F7 = t.UTC().Format(transmissionLayout)
+}
+
+// POSEntryMode gets F22 POS entry mode.
+func (tx *Transaction) POSEntryMode() string {
+	return tx.F22
+}
+
+// AcquirerID gets F32 acquiring institution identification code.
+func (tx *Transaction) AcquirerID() string {
+	return tx.F32
+}
+
+// RRN gets F37 retrieval reference number.
+func (tx *Transaction) RRN() string {
+	return tx.F37
+}
+
+// TerminalID gets F41 card acceptor terminal identification.
+func (tx *Transaction) TerminalID() string {
+	return strings.TrimSpace(tx.F41)
+}
+
+// MerchantID gets F42 card acceptor identification code.
+func (tx *Transaction) MerchantID() string {
+	return strings.TrimSpace(tx.F42)
+}
+
+// CurrencyCode gets F49 transaction currency code.
+func (tx *Transaction) CurrencyCode() string {
+	return tx.F49
+}
+
+// PINBlock gets F52 PIN block bytes.
+func (tx *Transaction) PINBlock() ([]byte, error) {
+	return hex.DecodeString(tx.F52)
+}
+
+// SetPINBlock sets F52 PIN block bytes.
+func (tx *Transaction) SetPINBlock(block []byte) {
+	tx.F52 = strings.ToUpper(hex.EncodeToString(block))
+}
+
+// EMVData gets F55 ICC data bytes.
+func (tx *Transaction) EMVData() ([]byte, error) {
+	return hex.DecodeString(tx.F55)
+}
+
+// SetEMVData sets F55 ICC data bytes.
+func (tx *Transaction) SetEMVData(data []byte) {
+	tx.F55 = strings.ToUpper(hex.EncodeToString(data))
+}
+
+// OriginalDataElements gets F90 original data elements.
+func (tx *Transaction) OriginalDataElements() (*OriginalData, error) {
+	if len(tx.F90) != originalDataLength {
+		return nil, fmt.Errorf("f90 length %d, expected %d", len(tx.F90), originalDataLength)
+	}
+	return &OriginalData{
+		MTI:                  tx.F90[0:4],
+		STAN:                 tx.F90[4:10],
+		TransmissionDateTime: tx.F90[10:20],
+		AcquirerID:           tx.F90[20:31],
+		ForwarderID:          tx.F90[31:42],
+	}, nil
+}
+
+// SetOriginalDataElements sets F90 original data elements.
+func (tx *Transaction) SetOriginalDataElements(od *OriginalData) {
+	tx.F90 = fmt.Sprintf("%4s%06s%010s%011s%011s", od.MTI, od.STAN, od.TransmissionDateTime, od.AcquirerID, od.ForwarderID)
+}
@@ -0,0 +1,101 @@
+// Package codec provides the wire formats used to exchange transactions with a franchise.
+package codec
+
+import (
+	"encoding/json"
+	"fmt"
+	"megalink/gateway/shared"
+	"megalink/gateway/shared/iso8583"
+)
+
+const (
+	// JSON transactions as JSON documents.
+	JSON = "json"
+	// ISO8583ASCII ISO 8583 messages with ASCII fields and hex bitmaps.
+	ISO8583ASCII = "iso8583-ascii"
+	// ISO8583Binary ISO 8583 messages with BCD numeric fields and binary bitmaps.
+	ISO8583Binary = "iso8583-binary"
+)
+
+type (
+	// Codec encodes and decodes transactions to and from the wire.
+	Codec interface {
+		Encode(tx *shared.Transaction) ([]byte, error)
+		Decode(data []byte) (*shared.Transaction, error)
+		// MACData gets the bytes of the encoded tx covered by its MAC, set in field.
+		MACData(tx *shared.Transaction, field int) ([]byte, error)
+	}
+
+	// JSONCodec implements Codec with encoding/json.
+	JSONCodec struct{}
+
+	// ISO8583Codec implements Codec with an ISO 8583 dialect.
+	ISO8583Codec struct {
+		Spec *iso8583.Spec
+	}
+)
+
+// NewCodec provides the Codec registered under name.
+// ISO 8583 codecs use the dialect described in specPath when it is not empty instead of the built in one.
+func NewCodec(name string, specPath string) (Codec, error) {
+	var spec *iso8583.Spec
+	switch name {
+	case JSON:
+		return &JSONCodec{}, nil
+	case ISO8583ASCII:
+		spec = iso8583.ASCIISpec()
+	case ISO8583Binary:
+		spec = iso8583.BinarySpec()
+	default:
+		return nil, fmt.Errorf("unknown codec %q", name)
+	}
+
+	if specPath != "" {
+		loaded, err := iso8583.LoadSpec(specPath)
+		if err != nil {
+			return nil, err
+		}
+		spec = loaded
+	}
+	return &ISO8583Codec{Spec: spec}, nil
+}
+
+// Encode marshals tx to JSON.
+func (c *JSONCodec) Encode(tx *shared.Transaction) ([]byte, error) {
+	return json.Marshal(tx)
+}
+
+// Decode unmarshals a JSON transaction.
+func (c *JSONCodec) Decode(data []byte) (*shared.Transaction, error) {
+	var tx shared.Transaction
+	if err := json.Unmarshal(data, &tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// MACData gets the JSON of tx without its MAC fields, there is no wire position to stop at.
+func (c *JSONCodec) MACData(tx *shared.Transaction, _ int) ([]byte, error) {
+	unsigned := *tx
+	unsigned.F64, unsigned.F128 = "", ""
+	return json.Marshal(&unsigned)
+}
+
+// Encode packs tx as an ISO 8583 message.
+func (c *ISO8583Codec) Encode(tx *shared.Transaction) ([]byte, error) {
+	return c.Spec.Marshal(tx)
+}
+
+// Decode unpacks an ISO 8583 message.
+func (c *ISO8583Codec) Decode(data []byte) (*shared.Transaction, error) {
+	var tx shared.Transaction
+	if err := c.Spec.Unmarshal(data, &tx); err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// MACData gets the packed message up to the value of field, flagged in the bitmap.
+func (c *ISO8583Codec) MACData(tx *shared.Transaction, field int) ([]byte, error) {
+	return c.Spec.MACData(tx, field)
+}
@@ -0,0 +1,65 @@
+package shared
+
+import (
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+var (
+	fieldIndexOnce sync.Once
+	// fieldIndex maps an ISO 8583 data element number to the Transaction struct field holding it.
+	fieldIndex map[int]int
+)
+
+func loadFieldIndex() {
+	fieldIndex = make(map[int]int)
+	t := reflect.TypeOf(Transaction{})
+	for i := 0; i < t.NumField(); i++ {
+		tag, ok := t.Field(i).Tag.Lookup("iso")
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(tag)
+		if err != nil {
+			continue
+		}
+		fieldIndex[n] = i
+	}
+}
+
+// Field gets the value of data element n, MTI being element 0.
+func (tx *Transaction) Field(n int) string {
+	fieldIndexOnce.Do(loadFieldIndex)
+	i, ok := fieldIndex[n]
+	if !ok {
+		return ""
+	}
+	return reflect.ValueOf(tx).Elem().Field(i).String()
+}
+
+// SetField sets the value of data element n, it returns false if the element is not supported.
+func (tx *Transaction) SetField(n int, value string) bool {
+	fieldIndexOnce.Do(loadFieldIndex)
+	i, ok := fieldIndex[n]
+	if !ok {
+		return false
+	}
+	reflect.ValueOf(tx).Elem().Field(i).SetString(value)
+	return true
+}
+
+// Fields gets every non empty data element of the transaction, MTI excluded.
+func (tx *Transaction) Fields() map[int]string {
+	fieldIndexOnce.Do(loadFieldIndex)
+	fields := make(map[int]string)
+	for n := range fieldIndex {
+		if n == 0 {
+			continue
+		}
+		if v := tx.Field(n); v != "" {
+			fields[n] = v
+		}
+	}
+	return fields
+}
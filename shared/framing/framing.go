@@ -0,0 +1,163 @@
+// Package framing delimits messages on a TCP stream with a length header and an optional TPDU.
+package framing
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+const (
+	// Binary2 2 bytes big endian length header.
+	Binary2 = "binary2"
+	// Binary4 4 bytes big endian length header.
+	Binary4 = "binary4"
+	// ASCII4 4 ASCII digits length header.
+	ASCII4 = "ascii4"
+	// BCD2 2 bytes BCD (4 digits) length header.
+	BCD2 = "bcd2"
+
+	// MaxFrameLength largest frame body accepted whatever the header could express, an ISO 8583
+	// message is a few KiB so a bigger length is a corrupt header and is never allocated.
+	MaxFrameLength = 64 * 1024
+
+	// tpduLength TPDU is made of an ID, a destination NII and a source NII.
+	tpduLength = 5
+)
+
+type (
+	// Framer wraps and unwraps messages exchanged over a stream.
+	Framer interface {
+		// Frame builds header and payload in a single buffer.
+		Frame(payload []byte) ([]byte, error)
+		// ReadHeader reads a length header from r and returns the size of the frame body.
+		ReadHeader(r io.Reader) (int, error)
+		// Unframe gets the payload of a frame body.
+		Unframe(body []byte) ([]byte, error)
+	}
+
+	// LengthFramer implements Framer with a length header and an optional TPDU.
+	LengthFramer struct {
+		// Kind of length header.
+		Kind string
+		// HeaderSize length header size in bytes.
+		HeaderSize int
+		// MaxLength biggest body accepted, what the header can express up to MaxFrameLength.
+		MaxLength int
+		// TPDU prepended to every payload, none if empty.
+		TPDU []byte
+	}
+)
+
+// NewFramer provides the Framer for a header kind, tpdu is an hex string of 5 bytes or empty.
+func NewFramer(kind string, tpdu string) (Framer, error) {
+	framer := &LengthFramer{Kind: kind}
+	switch kind {
+	case Binary2:
+		framer.HeaderSize, framer.MaxLength = 2, MaxFrameLength-1
+	case Binary4:
+		framer.HeaderSize, framer.MaxLength = 4, MaxFrameLength
+	case ASCII4:
+		framer.HeaderSize, framer.MaxLength = 4, 9999
+	case BCD2:
+		framer.HeaderSize, framer.MaxLength = 2, 9999
+	default:
+		return nil, fmt.Errorf("unknown framing %q", kind)
+	}
+
+	if tpdu != "" {
+		header, err := hex.DecodeString(tpdu)
+		if err != nil || len(header) != tpduLength {
+			return nil, fmt.Errorf("tpdu must be %d bytes in hex, got %q", tpduLength, tpdu)
+		}
+		framer.TPDU = header
+	}
+
+	return framer, nil
+}
+
+// Frame builds header, TPDU and payload in a single buffer.
+func (lf *LengthFramer) Frame(payload []byte) ([]byte, error) {
+	length := len(lf.TPDU) + len(payload)
+	if length > lf.MaxLength {
+		return nil, fmt.Errorf("frame length %d exceeds %s max %d", length, lf.Kind, lf.MaxLength)
+	}
+
+	frame := make([]byte, lf.HeaderSize, lf.HeaderSize+length)
+	switch lf.Kind {
+	case Binary2:
+		binary.BigEndian.PutUint16(frame, uint16(length))
+	case Binary4:
+		binary.BigEndian.PutUint32(frame, uint32(length))
+	case ASCII4:
+		copy(frame, fmt.Sprintf("%04d", length))
+	case BCD2:
+		digits, _ := hex.DecodeString(fmt.Sprintf("%04d", length))
+		copy(frame, digits)
+	}
+
+	frame = append(frame, lf.TPDU...)
+	return append(frame, payload...), nil
+}
+
+// ReadHeader reads a length header from r and returns the size of the frame body.
+func (lf *LengthFramer) ReadHeader(r io.Reader) (int, error) {
+	header := make([]byte, lf.HeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return 0, err
+	}
+
+	var length int
+	switch lf.Kind {
+	case Binary2:
+		length = int(binary.BigEndian.Uint16(header))
+	case Binary4:
+		length = int(binary.BigEndian.Uint32(header))
+	case ASCII4, BCD2:
+		digits := string(header)
+		if lf.Kind == BCD2 {
+			digits = hex.EncodeToString(header)
+		}
+		value, err := strconv.Atoi(digits)
+		if err != nil {
+			return 0, fmt.Errorf("invalid %s length header %X", lf.Kind, header)
+		}
+		length = value
+	}
+
+	if length > lf.MaxLength || length < len(lf.TPDU) {
+		return 0, fmt.Errorf("invalid %s frame length %d", lf.Kind, length)
+	}
+	return length, nil
+}
+
+// Unframe strips the TPDU from a frame body.
+func (lf *LengthFramer) Unframe(body []byte) ([]byte, error) {
+	if len(lf.TPDU) == 0 {
+		return body, nil
+	}
+	if len(body) < tpduLength {
+		return nil, fmt.Errorf("frame too short for tpdu")
+	}
+	// NIIs are swapped by the host on responses, so only the TPDU ID is checked.
+	if body[0] != lf.TPDU[0] {
+		return nil, fmt.Errorf("unexpected tpdu id %X", body[0])
+	}
+	return body[tpduLength:], nil
+}
+
+// ReadFrame reads a whole frame from r and returns its payload.
+func ReadFrame(framer Framer, r io.Reader) ([]byte, error) {
+	length, err := framer.ReadHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return framer.Unframe(body)
+}
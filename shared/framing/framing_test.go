@@ -0,0 +1,105 @@
+package framing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+func TestFrameHeaders(t *testing.T) {
+	payload := []byte("0800")
+	tests := []struct {
+		kind   string
+		tpdu   string
+		header string
+	}{
+		{kind: Binary2, header: "0004"},
+		{kind: Binary4, header: "00000004"},
+		{kind: ASCII4, header: hex.EncodeToString([]byte("0004"))},
+		{kind: BCD2, header: "0004"},
+		{kind: Binary2, tpdu: "6000010000", header: "0009" + "6000010000"},
+		{kind: ASCII4, tpdu: "6000010000", header: hex.EncodeToString([]byte("0009")) + "6000010000"},
+		{kind: BCD2, tpdu: "6000010000", header: "0009" + "6000010000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.kind+"/"+tt.tpdu, func(t *testing.T) {
+			framer, err := NewFramer(tt.kind, tt.tpdu)
+			if err != nil {
+				t.Fatalf("NewFramer: %v", err)
+			}
+			frame, err := framer.Frame(payload)
+			if err != nil {
+				t.Fatalf("Frame: %v", err)
+			}
+			want, _ := hex.DecodeString(tt.header)
+			want = append(want, payload...)
+			if !bytes.Equal(frame, want) {
+				t.Fatalf("Frame = %X, want %X", frame, want)
+			}
+
+			got, err := ReadFrame(framer, bytes.NewReader(frame))
+			if err != nil {
+				t.Fatalf("ReadFrame: %v", err)
+			}
+			if !bytes.Equal(got, payload) {
+				t.Errorf("ReadFrame = %q, want %q", got, payload)
+			}
+		})
+	}
+}
+
+func TestReadHeaderRejectsInvalidLengths(t *testing.T) {
+	tests := []struct {
+		name   string
+		kind   string
+		tpdu   string
+		header string
+	}{
+		{name: "binary4 over max", kind: Binary4, header: "7FFFFFFF"},
+		{name: "binary4 just over max", kind: Binary4, header: "00010001"},
+		{name: "ascii4 not digits", kind: ASCII4, header: hex.EncodeToString([]byte("00A4"))},
+		{name: "bcd2 not digits", kind: BCD2, header: "00AF"},
+		{name: "shorter than tpdu", kind: Binary2, tpdu: "6000010000", header: "0004"},
+		{name: "truncated header", kind: Binary4, header: "0000"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			framer, err := NewFramer(tt.kind, tt.tpdu)
+			if err != nil {
+				t.Fatalf("NewFramer: %v", err)
+			}
+			header, _ := hex.DecodeString(tt.header)
+			if _, err := framer.ReadHeader(bytes.NewReader(header)); err == nil {
+				t.Error("ReadHeader succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestFrameRejectsOversizedPayload(t *testing.T) {
+	for _, kind := range []string{Binary2, Binary4, ASCII4, BCD2} {
+		framer, err := NewFramer(kind, "")
+		if err != nil {
+			t.Fatalf("NewFramer %s: %v", kind, err)
+		}
+		if _, err := framer.Frame(make([]byte, MaxFrameLength+1)); err == nil {
+			t.Errorf("%s Frame of %d bytes succeeded, want error", kind, MaxFrameLength+1)
+		}
+	}
+}
+
+func TestUnframeChecksTPDU(t *testing.T) {
+	framer, err := NewFramer(Binary2, "6000010000")
+	if err != nil {
+		t.Fatalf("NewFramer: %v", err)
+	}
+	// the host swaps the NIIs on its responses.
+	if _, err := framer.Unframe([]byte{0x60, 0x00, 0x00, 0x00, 0x01, '0'}); err != nil {
+		t.Errorf("Unframe with swapped NIIs: %v", err)
+	}
+	if _, err := framer.Unframe([]byte{0x61, 0x00, 0x00, 0x00, 0x01, '0'}); err == nil {
+		t.Error("Unframe with another TPDU ID succeeded, want error")
+	}
+}
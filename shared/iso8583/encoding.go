@@ -0,0 +1,150 @@
+package iso8583
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Encoding is the wire representation of a value.
+type Encoding string
+
+const (
+	// ASCII one byte per character.
+	ASCII Encoding = "ascii"
+	// BCD two digits per byte, left padded with a zero nibble on odd lengths.
+	BCD Encoding = "bcd"
+	// Binary raw bytes, represented as an hex string on the transaction side.
+	Binary Encoding = "binary"
+)
+
+func (e Encoding) check() error {
+	switch e {
+	case ASCII, BCD, Binary:
+		return nil
+	}
+	return fmt.Errorf("unknown encoding %q", e)
+}
+
+// byteLength gets the number of bytes needed to hold length units of a value.
+func (e Encoding) byteLength(length int) int {
+	if e == BCD {
+		return (length + 1) / 2
+	}
+	return length
+}
+
+// valueLength gets the length in units of value: characters, digits or bytes.
+func (e Encoding) valueLength(value string) int {
+	if e == Binary {
+		return len(value) / 2
+	}
+	return len(value)
+}
+
+func (e Encoding) encode(value string) ([]byte, error) {
+	switch e {
+	case ASCII:
+		return []byte(value), nil
+	case BCD:
+		if !isDigits(value) {
+			return nil, fmt.Errorf("bcd value %q is not numeric", value)
+		}
+		if len(value)%2 != 0 {
+			value = "0" + value
+		}
+		return hex.DecodeString(value)
+	case Binary:
+		b, err := hex.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("binary value is not hex: %w", err)
+		}
+		return b, nil
+	}
+	return nil, fmt.Errorf("unknown encoding %q", e)
+}
+
+func (e Encoding) decode(data []byte, length int) (string, error) {
+	switch e {
+	case ASCII:
+		return string(data), nil
+	case BCD:
+		value := hex.EncodeToString(data)
+		if !isDigits(value) {
+			return "", fmt.Errorf("bcd value %X is not numeric", data)
+		}
+		return value[len(value)-length:], nil
+	case Binary:
+		return strings.ToUpper(hex.EncodeToString(data)), nil
+	}
+	return "", fmt.Errorf("unknown encoding %q", e)
+}
+
+// encodeLength encodes a variable length prefix of the given number of digits.
+func (e Encoding) encodeLength(length, digits int) ([]byte, error) {
+	if length >= pow10(digits) {
+		return nil, fmt.Errorf("length %d does not fit in %d digits", length, digits)
+	}
+	switch e {
+	case ASCII:
+		return []byte(fmt.Sprintf("%0*d", digits, length)), nil
+	case BCD:
+		return e.encode(fmt.Sprintf("%0*d", digits, length))
+	case Binary:
+		b := make([]byte, 2)
+		binary.BigEndian.PutUint16(b, uint16(length))
+		return b[2-e.lengthSize(digits):], nil
+	}
+	return nil, fmt.Errorf("unknown length encoding %q", e)
+}
+
+// lengthSize gets the number of bytes of a variable length prefix of the given number of digits.
+func (e Encoding) lengthSize(digits int) int {
+	switch e {
+	case ASCII:
+		return digits
+	default:
+		return (digits + 1) / 2
+	}
+}
+
+func (e Encoding) decodeLength(data []byte, digits int) (int, error) {
+	switch e {
+	case ASCII, BCD:
+		value, err := e.decode(data, digits)
+		if err != nil {
+			return 0, err
+		}
+		length, err := strconv.Atoi(value)
+		if err != nil {
+			return 0, fmt.Errorf("invalid length prefix %q", value)
+		}
+		return length, nil
+	case Binary:
+		length := 0
+		for _, b := range data {
+			length = length<<8 | int(b)
+		}
+		return length, nil
+	}
+	return 0, fmt.Errorf("unknown length encoding %q", e)
+}
+
+func isDigits(value string) bool {
+	for _, r := range value {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+func pow10(n int) int {
+	p := 1
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	return p
+}
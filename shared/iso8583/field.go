@@ -0,0 +1,248 @@
+package iso8583
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// LengthType tells how the length of a field is determined.
+type LengthType string
+
+const (
+	// Fixed fields always take MaxLength units.
+	Fixed LengthType = "fixed"
+	// LLVAR fields are prefixed with a 2 digits length.
+	LLVAR LengthType = "llvar"
+	// LLLVAR fields are prefixed with a 3 digits length.
+	LLLVAR LengthType = "lllvar"
+)
+
+// Padding tells on which side a fixed field is filled up to its length.
+type Padding string
+
+const (
+	// NoPadding values must match the fixed length exactly.
+	NoPadding Padding = ""
+	// PadLeft right aligns the value, used for numeric fields.
+	PadLeft Padding = "left"
+	// PadRight left aligns the value, used for alphanumeric fields.
+	PadRight Padding = "right"
+)
+
+// FieldType is the ISO 8583 content class of a field.
+type FieldType string
+
+const (
+	// Numeric n fields.
+	Numeric FieldType = "n"
+	// Alpha a fields.
+	Alpha FieldType = "a"
+	// AlphaNumeric an fields.
+	AlphaNumeric FieldType = "an"
+	// AlphaNumericSpecial ans fields.
+	AlphaNumericSpecial FieldType = "ans"
+	// Bytes b fields.
+	Bytes FieldType = "b"
+)
+
+// FieldSpec describes how a data element is packed.
+type FieldSpec struct {
+	// Description human readable meaning of the field.
+	Description string `json:"description" yaml:"description"`
+	// Type content class of the field.
+	Type FieldType `json:"type" yaml:"type"`
+	// LengthType fixed or variable length.
+	LengthType LengthType `json:"length_type" yaml:"length_type"`
+	// MaxLength exact length for fixed fields, maximum length for variable ones.
+	MaxLength int `json:"max_length" yaml:"max_length"`
+	// Encoding of the field value.
+	Encoding Encoding `json:"encoding" yaml:"encoding"`
+	// LengthEncoding of the LLVAR/LLLVAR prefix.
+	LengthEncoding Encoding `json:"length_encoding,omitempty" yaml:"length_encoding,omitempty"`
+	// Padding of fixed fields shorter than MaxLength.
+	Padding Padding `json:"padding,omitempty" yaml:"padding,omitempty"`
+	// PadChar character used to pad, defaults to "0" on left and " " on right.
+	PadChar string `json:"pad_char,omitempty" yaml:"pad_char,omitempty"`
+}
+
+// Validate checks the field definition is consistent.
+func (fs *FieldSpec) Validate() error {
+	switch fs.Type {
+	case Numeric, Alpha, AlphaNumeric, AlphaNumericSpecial, Bytes:
+	default:
+		return fmt.Errorf("unknown type %q", fs.Type)
+	}
+	switch fs.LengthType {
+	case Fixed, LLVAR, LLLVAR:
+	default:
+		return fmt.Errorf("unknown length type %q", fs.LengthType)
+	}
+	if fs.MaxLength <= 0 {
+		return fmt.Errorf("max length must be positive")
+	}
+	if fs.LengthType != Fixed && fs.MaxLength >= pow10(fs.lengthDigits()) {
+		return fmt.Errorf("max length %d does not fit in a %s prefix", fs.MaxLength, fs.LengthType)
+	}
+	if err := fs.Encoding.check(); err != nil {
+		return err
+	}
+	if fs.Encoding == BCD && fs.Type != Numeric {
+		return fmt.Errorf("bcd encoding requires a numeric type")
+	}
+	if (fs.Encoding == Binary) != (fs.Type == Bytes) {
+		return fmt.Errorf("binary encoding is only allowed for b type")
+	}
+	if fs.LengthType != Fixed {
+		if err := fs.LengthEncoding.check(); err != nil {
+			return fmt.Errorf("length %w", err)
+		}
+	}
+	switch fs.Padding {
+	case NoPadding, PadLeft, PadRight:
+	default:
+		return fmt.Errorf("unknown padding %q", fs.Padding)
+	}
+	if len(fs.PadChar) > 1 {
+		return fmt.Errorf("pad char must be a single character")
+	}
+
+	return nil
+}
+
+// validate checks value content matches the field type.
+func (fs *FieldSpec) validate(value string) error {
+	var valid func(r rune) bool
+	switch fs.Type {
+	case Numeric:
+		valid = func(r rune) bool { return r >= '0' && r <= '9' }
+	case Alpha:
+		valid = func(r rune) bool { return unicode.IsLetter(r) || r == ' ' }
+	case AlphaNumeric:
+		valid = func(r rune) bool { return unicode.IsLetter(r) || unicode.IsDigit(r) || r == ' ' }
+	case AlphaNumericSpecial:
+		valid = func(r rune) bool { return r < unicode.MaxASCII && unicode.IsPrint(r) }
+	case Bytes:
+		valid = func(r rune) bool { return strings.ContainsRune("0123456789abcdefABCDEF", r) }
+	default:
+		return nil
+	}
+
+	for _, r := range value {
+		if !valid(r) {
+			return fmt.Errorf("invalid character %q for type %s", r, fs.Type)
+		}
+	}
+	if fs.Type == Bytes && len(value)%2 != 0 {
+		return fmt.Errorf("odd number of hex characters for type %s", fs.Type)
+	}
+	return nil
+}
+
+func (fs *FieldSpec) lengthDigits() int {
+	switch fs.LengthType {
+	case LLVAR:
+		return 2
+	case LLLVAR:
+		return 3
+	}
+	return 0
+}
+
+func (fs *FieldSpec) padChar() string {
+	if fs.PadChar != "" {
+		return fs.PadChar
+	}
+	if fs.Padding == PadLeft {
+		return "0"
+	}
+	return " "
+}
+
+func (fs *FieldSpec) pad(value string) (string, error) {
+	length := fs.Encoding.valueLength(value)
+	if length == fs.MaxLength {
+		return value, nil
+	}
+	if length > fs.MaxLength {
+		return "", fmt.Errorf("length %d exceeds fixed length %d", length, fs.MaxLength)
+	}
+
+	fill := strings.Repeat(fs.padChar(), fs.MaxLength-length)
+	if fs.Encoding == Binary {
+		fill = strings.Repeat(fill, 2)
+	}
+	switch fs.Padding {
+	case PadLeft:
+		return fill + value, nil
+	case PadRight:
+		return value + fill, nil
+	}
+	return "", fmt.Errorf("length %d does not match fixed length %d", length, fs.MaxLength)
+}
+
+func (fs *FieldSpec) unpad(value string) string {
+	if fs.Padding == PadRight {
+		return strings.TrimRight(value, fs.padChar())
+	}
+	return value
+}
+
+func (fs *FieldSpec) pack(value string) ([]byte, error) {
+	if err := fs.validate(value); err != nil {
+		return nil, err
+	}
+
+	if fs.LengthType == Fixed {
+		padded, err := fs.pad(value)
+		if err != nil {
+			return nil, err
+		}
+		return fs.Encoding.encode(padded)
+	}
+
+	length := fs.Encoding.valueLength(value)
+	if length > fs.MaxLength {
+		return nil, fmt.Errorf("length %d exceeds max length %d", length, fs.MaxLength)
+	}
+	prefix, err := fs.LengthEncoding.encodeLength(length, fs.lengthDigits())
+	if err != nil {
+		return nil, err
+	}
+	data, err := fs.Encoding.encode(value)
+	if err != nil {
+		return nil, err
+	}
+	return append(prefix, data...), nil
+}
+
+// unpack reads the field from the start of data, it returns the value and the number of bytes read.
+func (fs *FieldSpec) unpack(data []byte) (string, int, error) {
+	length := fs.MaxLength
+	read := 0
+	if fs.LengthType != Fixed {
+		size := fs.LengthEncoding.lengthSize(fs.lengthDigits())
+		if len(data) < size {
+			return "", 0, fmt.Errorf("missing length prefix")
+		}
+		var err error
+		length, err = fs.LengthEncoding.decodeLength(data[:size], fs.lengthDigits())
+		if err != nil {
+			return "", 0, err
+		}
+		if length > fs.MaxLength {
+			return "", 0, fmt.Errorf("length %d exceeds max length %d", length, fs.MaxLength)
+		}
+		read = size
+	}
+
+	size := fs.Encoding.byteLength(length)
+	if len(data) < read+size {
+		return "", 0, fmt.Errorf("expected %d bytes, got %d", size, len(data)-read)
+	}
+	value, err := fs.Encoding.decode(data[read:read+size], length)
+	if err != nil {
+		return "", 0, err
+	}
+	return fs.unpad(value), read + size, nil
+}
@@ -0,0 +1,63 @@
+package iso8583
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadSpec reads a franchise dialect from a YAML or JSON file, the format is picked by extension.
+func LoadSpec(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading spec file: %w", err)
+	}
+
+	spec := &Spec{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, spec)
+	case ".json":
+		err = json.Unmarshal(data, spec)
+	default:
+		return nil, fmt.Errorf("unsupported spec file extension %q", filepath.Ext(path))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing spec file %s: %w", path, err)
+	}
+
+	if err := spec.Validate(); err != nil {
+		return nil, fmt.Errorf("spec file %s: %w", path, err)
+	}
+	return spec, nil
+}
+
+// Validate checks every definition of the dialect.
+func (s *Spec) Validate() error {
+	if err := s.MTIEncoding.check(); err != nil || s.MTIEncoding == Binary {
+		return fmt.Errorf("invalid mti encoding %q", s.MTIEncoding)
+	}
+	if s.BitmapEncoding != ASCII && s.BitmapEncoding != Binary {
+		return fmt.Errorf("invalid bitmap encoding %q", s.BitmapEncoding)
+	}
+	if len(s.Fields) == 0 {
+		return fmt.Errorf("no fields defined")
+	}
+
+	for n, fs := range s.Fields {
+		if n <= secondaryBitmapField || n > maxField {
+			return fmt.Errorf("field %d out of range", n)
+		}
+		if fs == nil {
+			return fmt.Errorf("field %d: empty definition", n)
+		}
+		if err := fs.Validate(); err != nil {
+			return fmt.Errorf("field %d: %w", n, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,214 @@
+// Package iso8583 packs and unpacks ISO 8583 messages.
+package iso8583
+
+import (
+	"bytes"
+	"fmt"
+	"megalink/gateway/shared"
+	"sort"
+	"strings"
+)
+
+const (
+	mtiLength    = 4
+	bitmapLength = 8
+	// secondaryBitmapField data element flagging the presence of fields 65 to 128.
+	secondaryBitmapField = 1
+	maxField             = 128
+)
+
+// Message is an ISO 8583 message as a MTI and its data elements.
+type Message struct {
+	MTI    string
+	Fields map[int]string
+}
+
+// Pack encodes a message with the given spec.
+func (s *Spec) Pack(msg *Message) ([]byte, error) {
+	var buf bytes.Buffer
+
+	if len(msg.MTI) != mtiLength {
+		return nil, fmt.Errorf("invalid mti %q", msg.MTI)
+	}
+	mti, err := s.MTIEncoding.encode(msg.MTI)
+	if err != nil {
+		return nil, fmt.Errorf("mti: %w", err)
+	}
+	buf.Write(mti)
+
+	numbers := make([]int, 0, len(msg.Fields))
+	for n := range msg.Fields {
+		if n <= secondaryBitmapField || n > maxField {
+			return nil, fmt.Errorf("field %d out of range", n)
+		}
+		if _, ok := s.Fields[n]; !ok {
+			return nil, fmt.Errorf("field %d not defined in spec %s", n, s.Name)
+		}
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	bitmap := make([]byte, bitmapLength)
+	for _, n := range numbers {
+		if n > bitmapLength*8 && len(bitmap) == bitmapLength {
+			bitmap = append(bitmap, make([]byte, bitmapLength)...)
+			setBit(bitmap, secondaryBitmapField)
+		}
+		setBit(bitmap, n)
+	}
+	buf.Write(s.encodeBitmap(bitmap))
+
+	for _, n := range numbers {
+		data, err := s.Fields[n].pack(msg.Fields[n])
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", n, err)
+		}
+		buf.Write(data)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// Unpack decodes a message with the given spec.
+func (s *Spec) Unpack(data []byte) (*Message, error) {
+	size := s.MTIEncoding.byteLength(mtiLength)
+	if len(data) < size {
+		return nil, fmt.Errorf("message too short for mti")
+	}
+	mti, err := s.MTIEncoding.decode(data[:size], mtiLength)
+	if err != nil {
+		return nil, fmt.Errorf("mti: %w", err)
+	}
+	offset := size
+
+	bitmap, read, err := s.decodeBitmap(data[offset:])
+	if err != nil {
+		return nil, err
+	}
+	offset += read
+
+	msg := &Message{MTI: mti, Fields: make(map[int]string)}
+	for n := secondaryBitmapField + 1; n <= len(bitmap)*8; n++ {
+		if !isBitSet(bitmap, n) {
+			continue
+		}
+		fs, ok := s.Fields[n]
+		if !ok {
+			return nil, fmt.Errorf("field %d not defined in spec %s", n, s.Name)
+		}
+		value, read, err := fs.unpack(data[offset:])
+		if err != nil {
+			return nil, fmt.Errorf("field %d: %w", n, err)
+		}
+		msg.Fields[n] = value
+		offset += read
+	}
+
+	if offset != len(data) {
+		return nil, fmt.Errorf("%d trailing bytes after last field", len(data)-offset)
+	}
+
+	return msg, nil
+}
+
+// Marshal packs a transaction with the given spec.
+func (s *Spec) Marshal(tx *shared.Transaction) ([]byte, error) {
+	return s.Pack(&Message{MTI: tx.MTI, Fields: tx.Fields()})
+}
+
+// Unmarshal unpacks data into a transaction with the given spec.
+func (s *Spec) Unmarshal(data []byte, tx *shared.Transaction) error {
+	msg, err := s.Unpack(data)
+	if err != nil {
+		return err
+	}
+
+	tx.MTI = msg.MTI
+	for n, value := range msg.Fields {
+		if !tx.SetField(n, value) {
+			return fmt.Errorf("field %d not supported by transaction", n)
+		}
+	}
+	return nil
+}
+
+// MACData packs tx with field, its MAC, flagged in the bitmap and gets the bytes preceding the MAC value.
+// The MAC must be the last field of the message.
+func (s *Spec) MACData(tx *shared.Transaction, field int) ([]byte, error) {
+	fs, ok := s.Fields[field]
+	if !ok {
+		return nil, fmt.Errorf("mac field %d not defined in spec %s", field, s.Name)
+	}
+	fields := tx.Fields()
+	for n := range fields {
+		if n > field {
+			return nil, fmt.Errorf("field %d after mac field %d", n, field)
+		}
+	}
+
+	placeholder := strings.Repeat("0", fs.MaxLength)
+	if fs.Type == Bytes {
+		placeholder += placeholder
+	}
+	packed, err := fs.pack(placeholder)
+	if err != nil {
+		return nil, fmt.Errorf("field %d: %w", field, err)
+	}
+	fields[field] = placeholder
+	data, err := s.Pack(&Message{MTI: tx.MTI, Fields: fields})
+	if err != nil {
+		return nil, err
+	}
+	return data[:len(data)-len(packed)], nil
+}
+
+func (s *Spec) encodeBitmap(bitmap []byte) []byte {
+	if s.BitmapEncoding == ASCII {
+		return []byte(fmt.Sprintf("%X", bitmap))
+	}
+	return bitmap
+}
+
+func (s *Spec) decodeBitmap(data []byte) ([]byte, int, error) {
+	size := bitmapLength
+	if s.BitmapEncoding == ASCII {
+		size *= 2
+	}
+
+	bitmap, err := s.decodeBitmapBlock(data, size)
+	if err != nil {
+		return nil, 0, fmt.Errorf("primary bitmap: %w", err)
+	}
+	if !isBitSet(bitmap, secondaryBitmapField) {
+		return bitmap, size, nil
+	}
+
+	secondary, err := s.decodeBitmapBlock(data[size:], size)
+	if err != nil {
+		return nil, 0, fmt.Errorf("secondary bitmap: %w", err)
+	}
+	return append(bitmap, secondary...), size * 2, nil
+}
+
+func (s *Spec) decodeBitmapBlock(data []byte, size int) ([]byte, error) {
+	if len(data) < size {
+		return nil, fmt.Errorf("expected %d bytes, got %d", size, len(data))
+	}
+	if s.BitmapEncoding != ASCII {
+		return append([]byte(nil), data[:size]...), nil
+	}
+
+	value, err := Binary.encode(string(data[:size]))
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func setBit(bitmap []byte, n int) {
+	bitmap[(n-1)/8] |= 0x80 >> uint((n-1)%8)
+}
+
+func isBitSet(bitmap []byte, n int) bool {
+	return bitmap[(n-1)/8]&(0x80>>uint((n-1)%8)) != 0
+}
@@ -0,0 +1,129 @@
+package iso8583
+
+import (
+	"bytes"
+	"megalink/gateway/shared"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestPackUnpackRoundTrip(t *testing.T) {
+	tests := []struct {
+		name   string
+		fields map[int]string
+	}{
+		{
+			name:   "primary bitmap",
+			fields: map[int]string{2: "4111111111111111", 3: "000000", 4: "000000000100", 11: "000001", 37: "629015000001"},
+		},
+		{
+			name:   "secondary bitmap",
+			fields: map[int]string{7: "1017153043", 11: "000002", 70: "301"},
+		},
+		{
+			name:   "odd length numeric",
+			fields: map[int]string{2: "411111111111111", 14: "2812", 32: "12345"},
+		},
+		{
+			name:   "variable length bounds",
+			fields: map[int]string{2: strings.Repeat("4", 19), 35: "", 36: strings.Repeat("A", 104), 48: strings.Repeat("x", 999)},
+		},
+		{
+			name:   "fixed length padding",
+			fields: map[int]string{4: "000000000100", 37: "RRN1", 64: "0123456789ABCDEF"},
+		},
+	}
+
+	specs := []*Spec{ASCIISpec(), BinarySpec()}
+	for _, spec := range specs {
+		for _, tt := range tests {
+			t.Run(spec.Name+"/"+tt.name, func(t *testing.T) {
+				data, err := spec.Pack(&Message{MTI: "0200", Fields: tt.fields})
+				if err != nil {
+					t.Fatalf("Pack: %v", err)
+				}
+				msg, err := spec.Unpack(data)
+				if err != nil {
+					t.Fatalf("Unpack: %v", err)
+				}
+				if msg.MTI != "0200" {
+					t.Errorf("MTI = %q, want 0200", msg.MTI)
+				}
+				if !reflect.DeepEqual(msg.Fields, tt.fields) {
+					t.Errorf("Fields = %v, want %v", msg.Fields, tt.fields)
+				}
+			})
+		}
+	}
+}
+
+func TestPackRejectsInvalidFields(t *testing.T) {
+	tests := []struct {
+		name   string
+		mti    string
+		fields map[int]string
+	}{
+		{name: "short mti", mti: "020", fields: map[int]string{11: "000001"}},
+		{name: "llvar over max", mti: "0200", fields: map[int]string{2: strings.Repeat("4", 20)}},
+		{name: "lllvar over max", mti: "0200", fields: map[int]string{36: strings.Repeat("A", 105)}},
+		{name: "fixed over length", mti: "0200", fields: map[int]string{4: "0000000001000"}},
+		{name: "processing code over length", mti: "0200", fields: map[int]string{3: "0000000"}},
+		{name: "letters in numeric", mti: "0200", fields: map[int]string{4: "10A"}},
+		{name: "odd bytes", mti: "0200", fields: map[int]string{64: "0123456789ABCDE"}},
+		{name: "undefined field", mti: "0200", fields: map[int]string{1: "x"}},
+	}
+
+	for _, spec := range []*Spec{ASCIISpec(), BinarySpec()} {
+		for _, tt := range tests {
+			t.Run(spec.Name+"/"+tt.name, func(t *testing.T) {
+				if _, err := spec.Pack(&Message{MTI: tt.mti, Fields: tt.fields}); err == nil {
+					t.Error("Pack succeeded, want error")
+				}
+			})
+		}
+	}
+}
+
+func TestUnpackRejectsTruncatedMessages(t *testing.T) {
+	for _, spec := range []*Spec{ASCIISpec(), BinarySpec()} {
+		data, err := spec.Pack(&Message{MTI: "0800", Fields: map[int]string{11: "000001", 70: "301"}})
+		if err != nil {
+			t.Fatalf("%s Pack: %v", spec.Name, err)
+		}
+		for _, size := range []int{0, 2, len(data) - 1} {
+			if _, err := spec.Unpack(data[:size]); err == nil {
+				t.Errorf("%s Unpack of %d bytes succeeded, want error", spec.Name, size)
+			}
+		}
+		if _, err := spec.Unpack(append(data, '0')); err == nil {
+			t.Errorf("%s Unpack with trailing byte succeeded, want error", spec.Name)
+		}
+	}
+}
+
+func TestMACData(t *testing.T) {
+	for _, spec := range []*Spec{ASCIISpec(), BinarySpec()} {
+		tx := &shared.Transaction{MTI: "0200", F4: "000000000100", F11: "000001"}
+		data, err := spec.MACData(tx, 64)
+		if err != nil {
+			t.Fatalf("%s MACData: %v", spec.Name, err)
+		}
+
+		signed := *tx
+		signed.F64 = "0102030405060708"
+		packed, err := spec.Marshal(&signed)
+		if err != nil {
+			t.Fatalf("%s Marshal: %v", spec.Name, err)
+		}
+		if want := packed[:len(packed)-8]; !bytes.Equal(data, want) {
+			t.Errorf("%s MACData = %X, want %X", spec.Name, data, want)
+		}
+
+		secondary := *tx
+		secondary.F70 = "301"
+		if _, err := spec.MACData(&secondary, 64); err == nil {
+			t.Errorf("%s MACData with a field after the MAC succeeded, want error", spec.Name)
+		}
+	}
+}
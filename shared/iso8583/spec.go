@@ -0,0 +1,199 @@
+package iso8583
+
+// Spec describes a franchise ISO 8583 dialect.
+type Spec struct {
+	// Name of the dialect.
+	Name string `json:"name" yaml:"name"`
+	// MTIEncoding ASCII or BCD.
+	MTIEncoding Encoding `json:"mti_encoding" yaml:"mti_encoding"`
+	// BitmapEncoding Binary or ASCII (hex characters).
+	BitmapEncoding Encoding `json:"bitmap_encoding" yaml:"bitmap_encoding"`
+	// Fields data elements known by the dialect, indexed by number.
+	Fields map[int]*FieldSpec `json:"fields" yaml:"fields"`
+}
+
+type fieldDef struct {
+	number      int
+	description string
+	fieldType   FieldType
+	lengthType  LengthType
+	maxLength   int
+}
+
+// fieldDefs ISO 8583:1987 data elements.
+var fieldDefs = []fieldDef{
+	{2, "card number", Numeric, LLVAR, 19},
+	{3, "processing code", Numeric, Fixed, 6},
+	{4, "amount", Numeric, Fixed, 12},
+	{5, "settlement amount", Numeric, Fixed, 12},
+	{6, "cardholder billing amount", Numeric, Fixed, 12},
+	{7, "transmission date and time MMDDhhmmss", Numeric, Fixed, 10},
+	{8, "cardholder billing fee amount", Numeric, Fixed, 8},
+	{9, "settlement conversion rate", Numeric, Fixed, 8},
+	{10, "cardholder billing conversion rate", Numeric, Fixed, 8},
+	{11, "system trace audit number", Numeric, Fixed, 6},
+	{12, "local transaction time", Numeric, Fixed, 6},
+	{13, "local transaction date", Numeric, Fixed, 4},
+	{14, "expiration date YYMM", Numeric, Fixed, 4},
+	{15, "settlement date", Numeric, Fixed, 4},
+	{16, "currency conversion date", Numeric, Fixed, 4},
+	{17, "capture date", Numeric, Fixed, 4},
+	{18, "merchant type", Numeric, Fixed, 4},
+	{19, "acquiring institution country code", Numeric, Fixed, 3},
+	{20, "PAN extended country code", Numeric, Fixed, 3},
+	{21, "forwarding institution country code", Numeric, Fixed, 3},
+	{22, "POS entry mode", Numeric, Fixed, 3},
+	{23, "card sequence number", Numeric, Fixed, 3},
+	{24, "network international identifier", Numeric, Fixed, 3},
+	{25, "POS condition code", Numeric, Fixed, 2},
+	{26, "POS capture code", Numeric, Fixed, 2},
+	{27, "authorizing identification response length", Numeric, Fixed, 1},
+	{28, "transaction fee amount", AlphaNumericSpecial, Fixed, 9},
+	{29, "settlement fee amount", AlphaNumericSpecial, Fixed, 9},
+	{30, "transaction processing fee amount", AlphaNumericSpecial, Fixed, 9},
+	{31, "settlement processing fee amount", AlphaNumericSpecial, Fixed, 9},
+	{32, "acquiring institution identification code", Numeric, LLVAR, 11},
+	{33, "forwarding institution identification code", Numeric, LLVAR, 11},
+	{34, "extended PAN", AlphaNumericSpecial, LLVAR, 28},
+	{35, "track 2 data", AlphaNumericSpecial, LLVAR, 37},
+	{36, "track 3 data", AlphaNumericSpecial, LLLVAR, 104},
+	{37, "retrieval reference number", AlphaNumeric, Fixed, 12},
+	{38, "authorization code response", AlphaNumericSpecial, Fixed, 6},
+	{39, "response code", AlphaNumeric, Fixed, 2},
+	{40, "service restriction code", AlphaNumeric, Fixed, 3},
+	{41, "card acceptor terminal identification", AlphaNumericSpecial, Fixed, 8},
+	{42, "card acceptor identification code", AlphaNumericSpecial, Fixed, 15},
+	{43, "card acceptor name and location", AlphaNumericSpecial, Fixed, 40},
+	{44, "additional response data", AlphaNumericSpecial, LLVAR, 25},
+	{45, "track 1 data", AlphaNumericSpecial, LLVAR, 76},
+	{46, "additional data ISO", AlphaNumericSpecial, LLLVAR, 999},
+	{47, "additional data national", AlphaNumericSpecial, LLLVAR, 999},
+	{48, "additional data private", AlphaNumericSpecial, LLLVAR, 999},
+	{49, "transaction currency code", AlphaNumeric, Fixed, 3},
+	{50, "settlement currency code", AlphaNumeric, Fixed, 3},
+	{51, "cardholder billing currency code", AlphaNumeric, Fixed, 3},
+	{52, "PIN block", Bytes, Fixed, 8},
+	{53, "security related control information", Numeric, Fixed, 16},
+	{54, "additional amounts", AlphaNumericSpecial, LLLVAR, 120},
+	{55, "ICC EMV data", Bytes, LLLVAR, 999},
+	{56, "reserved ISO", AlphaNumericSpecial, LLLVAR, 999},
+	{57, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{58, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{59, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{60, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{61, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{62, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{63, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{64, "message authentication code", Bytes, Fixed, 8},
+	{65, "extended bitmap indicator", Bytes, Fixed, 1},
+	{66, "settlement code", Numeric, Fixed, 1},
+	{67, "extended payment code", Numeric, Fixed, 2},
+	{68, "receiving institution country code", Numeric, Fixed, 3},
+	{69, "settlement institution country code", Numeric, Fixed, 3},
+	{70, "network management information code", Numeric, Fixed, 3},
+	{71, "message number", Numeric, Fixed, 4},
+	{72, "last message number", Numeric, Fixed, 4},
+	{73, "action date YYMMDD", Numeric, Fixed, 6},
+	{74, "credits number", Numeric, Fixed, 10},
+	{75, "credits reversal number", Numeric, Fixed, 10},
+	{76, "debits number", Numeric, Fixed, 10},
+	{77, "debits reversal number", Numeric, Fixed, 10},
+	{78, "transfer number", Numeric, Fixed, 10},
+	{79, "transfer reversal number", Numeric, Fixed, 10},
+	{80, "inquiries number", Numeric, Fixed, 10},
+	{81, "authorizations number", Numeric, Fixed, 10},
+	{82, "credits processing fee amount", Numeric, Fixed, 12},
+	{83, "credits transaction fee amount", Numeric, Fixed, 12},
+	{84, "debits processing fee amount", Numeric, Fixed, 12},
+	{85, "debits transaction fee amount", Numeric, Fixed, 12},
+	{86, "credits amount", Numeric, Fixed, 16},
+	{87, "credits reversal amount", Numeric, Fixed, 16},
+	{88, "debits amount", Numeric, Fixed, 16},
+	{89, "debits reversal amount", Numeric, Fixed, 16},
+	{90, "original data elements", Numeric, Fixed, 42},
+	{91, "file update code", AlphaNumeric, Fixed, 1},
+	{92, "file security code", AlphaNumeric, Fixed, 2},
+	{93, "response indicator", AlphaNumeric, Fixed, 5},
+	{94, "service indicator", AlphaNumeric, Fixed, 7},
+	{95, "replacement amounts", AlphaNumeric, Fixed, 42},
+	{96, "message security code", Bytes, Fixed, 8},
+	{97, "net settlement amount", AlphaNumericSpecial, Fixed, 17},
+	{98, "payee", AlphaNumericSpecial, Fixed, 25},
+	{99, "settlement institution identification code", Numeric, LLVAR, 11},
+	{100, "receiving institution identification code", Numeric, LLVAR, 11},
+	{101, "file name", AlphaNumericSpecial, LLVAR, 17},
+	{102, "account identification 1", AlphaNumericSpecial, LLVAR, 28},
+	{103, "account identification 2", AlphaNumericSpecial, LLVAR, 28},
+	{104, "transaction description", AlphaNumericSpecial, LLLVAR, 100},
+	{105, "reserved ISO", AlphaNumericSpecial, LLLVAR, 999},
+	{106, "reserved ISO", AlphaNumericSpecial, LLLVAR, 999},
+	{107, "reserved ISO", AlphaNumericSpecial, LLLVAR, 999},
+	{108, "reserved ISO", AlphaNumericSpecial, LLLVAR, 999},
+	{109, "reserved ISO", AlphaNumericSpecial, LLLVAR, 999},
+	{110, "reserved ISO", AlphaNumericSpecial, LLLVAR, 999},
+	{111, "reserved ISO", AlphaNumericSpecial, LLLVAR, 999},
+	{112, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{113, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{114, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{115, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{116, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{117, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{118, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{119, "reserved national", AlphaNumericSpecial, LLLVAR, 999},
+	{120, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{121, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{122, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{123, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{124, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{125, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{126, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{127, "reserved private", AlphaNumericSpecial, LLLVAR, 999},
+	{128, "message authentication code", Bytes, Fixed, 8},
+}
+
+// ASCIISpec provides a dialect where everything travels as ASCII characters and bitmaps as hex.
+func ASCIISpec() *Spec {
+	return buildSpec("iso8583-ascii", ASCII, ASCII, func(FieldType) Encoding { return ASCII })
+}
+
+// BinarySpec provides a dialect with BCD MTI, lengths and numeric fields and binary bitmaps.
+func BinarySpec() *Spec {
+	return buildSpec("iso8583-binary", BCD, Binary, func(ft FieldType) Encoding {
+		if ft == Numeric {
+			return BCD
+		}
+		return ASCII
+	})
+}
+
+func buildSpec(name string, mtiEncoding, bitmapEncoding Encoding, encodingOf func(FieldType) Encoding) *Spec {
+	spec := &Spec{
+		Name:           name,
+		MTIEncoding:    mtiEncoding,
+		BitmapEncoding: bitmapEncoding,
+		Fields:         make(map[int]*FieldSpec, len(fieldDefs)),
+	}
+
+	for _, def := range fieldDefs {
+		fs := &FieldSpec{
+			Description:    def.description,
+			Type:           def.fieldType,
+			LengthType:     def.lengthType,
+			MaxLength:      def.maxLength,
+			Encoding:       encodingOf(def.fieldType),
+			LengthEncoding: encodingOf(Numeric),
+		}
+		if def.fieldType == Bytes {
+			fs.Encoding = Binary
+		}
+		if def.lengthType == Fixed {
+			fs.Padding = PadRight
+			if def.fieldType == Numeric {
+				fs.Padding = PadLeft
+			}
+		}
+		spec.Fields[def.number] = fs
+	}
+
+	return spec
+}
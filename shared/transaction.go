@@ -1,12 +1,133 @@
 package shared
 
+// Transaction holds the MTI and the ISO 8583 data elements 2 to 128, F1 (secondary bitmap) is left to the codec.
 type Transaction struct {
-	MTI string `json:"mti"`
-	F2  string `json:"f2"`  // card number
-	F3  string `json:"f3"`  // card expiry
-	F4  string `json:"f4"`  // amount
-	F12 string `json:"f12"` // local transaction time
-	F13 string `json:"f13"` // local transaction date
-	F38 string `json:"f38"` // authorization code response
-	F39 string `json:"f39"` // response code
+	MTI  string `json:"mti" iso:"0"`
+	F2   string `json:"f2" iso:"2"`               // card number
+	F3   string `json:"f3" iso:"3"`               // processing code
+	F4   string `json:"f4" iso:"4"`               // amount
+	F5   string `json:"f5,omitempty" iso:"5"`     // settlement amount
+	F6   string `json:"f6,omitempty" iso:"6"`     // cardholder billing amount
+	F7   string `json:"f7,omitempty" iso:"7"`     // transmission date and time MMDDhhmmss
+	F8   string `json:"f8,omitempty" iso:"8"`     // cardholder billing fee amount
+	F9   string `json:"f9,omitempty" iso:"9"`     // settlement conversion rate
+	F10  string `json:"f10,omitempty" iso:"10"`   // cardholder billing conversion rate
+	F11  string `json:"f11,omitempty" iso:"11"`   // system trace audit number
+	F12  string `json:"f12" iso:"12"`             // local transaction time
+	F13  string `json:"f13" iso:"13"`             // local transaction date
+	F14  string `json:"f14,omitempty" iso:"14"`   // expiration date YYMM
+	F15  string `json:"f15,omitempty" iso:"15"`   // settlement date
+	F16  string `json:"f16,omitempty" iso:"16"`   // currency conversion date
+	F17  string `json:"f17,omitempty" iso:"17"`   // capture date
+	F18  string `json:"f18,omitempty" iso:"18"`   // merchant type
+	F19  string `json:"f19,omitempty" iso:"19"`   // acquiring institution country code
+	F20  string `json:"f20,omitempty" iso:"20"`   // PAN extended country code
+	F21  string `json:"f21,omitempty" iso:"21"`   // forwarding institution country code
+	F22  string `json:"f22,omitempty" iso:"22"`   // POS entry mode
+	F23  string `json:"f23,omitempty" iso:"23"`   // card sequence number
+	F24  string `json:"f24,omitempty" iso:"24"`   // network international identifier
+	F25  string `json:"f25,omitempty" iso:"25"`   // POS condition code
+	F26  string `json:"f26,omitempty" iso:"26"`   // POS capture code
+	F27  string `json:"f27,omitempty" iso:"27"`   // authorizing identification response length
+	F28  string `json:"f28,omitempty" iso:"28"`   // transaction fee amount
+	F29  string `json:"f29,omitempty" iso:"29"`   // settlement fee amount
+	F30  string `json:"f30,omitempty" iso:"30"`   // transaction processing fee amount
+	F31  string `json:"f31,omitempty" iso:"31"`   // settlement processing fee amount
+	F32  string `json:"f32,omitempty" iso:"32"`   // acquiring institution identification code
+	F33  string `json:"f33,omitempty" iso:"33"`   // forwarding institution identification code
+	F34  string `json:"f34,omitempty" iso:"34"`   // extended PAN
+	F35  string `json:"f35,omitempty" iso:"35"`   // track 2 data
+	F36  string `json:"f36,omitempty" iso:"36"`   // track 3 data
+	F37  string `json:"f37,omitempty" iso:"37"`   // retrieval reference number
+	F38  string `json:"f38" iso:"38"`             // authorization code response
+	F39  string `json:"f39" iso:"39"`             // response code
+	F40  string `json:"f40,omitempty" iso:"40"`   // service restriction code
+	F41  string `json:"f41,omitempty" iso:"41"`   // card acceptor terminal identification
+	F42  string `json:"f42,omitempty" iso:"42"`   // card acceptor identification code
+	F43  string `json:"f43,omitempty" iso:"43"`   // card acceptor name and location
+	F44  string `json:"f44,omitempty" iso:"44"`   // additional response data
+	F45  string `json:"f45,omitempty" iso:"45"`   // track 1 data
+	F46  string `json:"f46,omitempty" iso:"46"`   // additional data ISO
+	F47  string `json:"f47,omitempty" iso:"47"`   // additional data national
+	F48  string `json:"f48,omitempty" iso:"48"`   // additional data private
+	F49  string `json:"f49,omitempty" iso:"49"`   // transaction currency code
+	F50  string `json:"f50,omitempty" iso:"50"`   // settlement currency code
+	F51  string `json:"f51,omitempty" iso:"51"`   // cardholder billing currency code
+	F52  string `json:"f52,omitempty" iso:"52"`   // PIN block
+	F53  string `json:"f53,omitempty" iso:"53"`   // security related control information
+	F54  string `json:"f54,omitempty" iso:"54"`   // additional amounts
+	F55  string `json:"f55,omitempty" iso:"55"`   // ICC EMV data
+	F56  string `json:"f56,omitempty" iso:"56"`   // reserved ISO
+	F57  string `json:"f57,omitempty" iso:"57"`   // reserved national
+	F58  string `json:"f58,omitempty" iso:"58"`   // reserved national
+	F59  string `json:"f59,omitempty" iso:"59"`   // reserved national
+	F60  string `json:"f60,omitempty" iso:"60"`   // reserved private
+	F61  string `json:"f61,omitempty" iso:"61"`   // reserved private
+	F62  string `json:"f62,omitempty" iso:"62"`   // reserved private
+	F63  string `json:"f63,omitempty" iso:"63"`   // reserved private
+	F64  string `json:"f64,omitempty" iso:"64"`   // message authentication code
+	F65  string `json:"f65,omitempty" iso:"65"`   // extended bitmap indicator
+	F66  string `json:"f66,omitempty" iso:"66"`   // settlement code
+	F67  string `json:"f67,omitempty" iso:"67"`   // extended payment code
+	F68  string `json:"f68,omitempty" iso:"68"`   // receiving institution country code
+	F69  string `json:"f69,omitempty" iso:"69"`   // settlement institution country code
+	F70  string `json:"f70,omitempty" iso:"70"`   // network management information code
+	F71  string `json:"f71,omitempty" iso:"71"`   // message number
+	F72  string `json:"f72,omitempty" iso:"72"`   // last message number
+	F73  string `json:"f73,omitempty" iso:"73"`   // action date YYMMDD
+	F74  string `json:"f74,omitempty" iso:"74"`   // credits number
+	F75  string `json:"f75,omitempty" iso:"75"`   // credits reversal number
+	F76  string `json:"f76,omitempty" iso:"76"`   // debits number
+	F77  string `json:"f77,omitempty" iso:"77"`   // debits reversal number
+	F78  string `json:"f78,omitempty" iso:"78"`   // transfer number
+	F79  string `json:"f79,omitempty" iso:"79"`   // transfer reversal number
+	F80  string `json:"f80,omitempty" iso:"80"`   // inquiries number
+	F81  string `json:"f81,omitempty" iso:"81"`   // authorizations number
+	F82  string `json:"f82,omitempty" iso:"82"`   // credits processing fee amount
+	F83  string `json:"f83,omitempty" iso:"83"`   // credits transaction fee amount
+	F84  string `json:"f84,omitempty" iso:"84"`   // debits processing fee amount
+	F85  string `json:"f85,omitempty" iso:"85"`   // debits transaction fee amount
+	F86  string `json:"f86,omitempty" iso:"86"`   // credits amount
+	F87  string `json:"f87,omitempty" iso:"87"`   // credits reversal amount
+	F88  string `json:"f88,omitempty" iso:"88"`   // debits amount
+	F89  string `json:"f89,omitempty" iso:"89"`   // debits reversal amount
+	F90  string `json:"f90,omitempty" iso:"90"`   // original data elements
+	F91  string `json:"f91,omitempty" iso:"91"`   // file update code
+	F92  string `json:"f92,omitempty" iso:"92"`   // file security code
+	F93  string `json:"f93,omitempty" iso:"93"`   // response indicator
+	F94  string `json:"f94,omitempty" iso:"94"`   // service indicator
+	F95  string `json:"f95,omitempty" iso:"95"`   // replacement amounts
+	F96  string `json:"f96,omitempty" iso:"96"`   // message security code
+	F97  string `json:"f97,omitempty" iso:"97"`   // net settlement amount
+	F98  string `json:"f98,omitempty" iso:"98"`   // payee
+	F99  string `json:"f99,omitempty" iso:"99"`   // settlement institution identification code
+	F100 string `json:"f100,omitempty" iso:"100"` // receiving institution identification code
+	F101 string `json:"f101,omitempty" iso:"101"` // file name
+	F102 string `json:"f102,omitempty" iso:"102"` // account identification 1
+	F103 string `json:"f103,omitempty" iso:"103"` // account identification 2
+	F104 string `json:"f104,omitempty" iso:"104"` // transaction description
+	F105 string `json:"f105,omitempty" iso:"105"` // reserved ISO
+	F106 string `json:"f106,omitempty" iso:"106"` // reserved ISO
+	F107 string `json:"f107,omitempty" iso:"107"` // reserved ISO
+	F108 string `json:"f108,omitempty" iso:"108"` // reserved ISO
+	F109 string `json:"f109,omitempty" iso:"109"` // reserved ISO
+	F110 string `json:"f110,omitempty" iso:"110"` // reserved ISO
+	F111 string `json:"f111,omitempty" iso:"111"` // reserved ISO
+	F112 string `json:"f112,omitempty" iso:"112"` // reserved national
+	F113 string `json:"f113,omitempty" iso:"113"` // reserved national
+	F114 string `json:"f114,omitempty" iso:"114"` // reserved national
+	F115 string `json:"f115,omitempty" iso:"115"` // reserved national
+	F116 string `json:"f116,omitempty" iso:"116"` // reserved national
+	F117 string `json:"f117,omitempty" iso:"117"` // reserved national
+	F118 string `json:"f118,omitempty" iso:"118"` // reserved national
+	F119 string `json:"f119,omitempty" iso:"119"` // reserved national
+	F120 string `json:"f120,omitempty" iso:"120"` // reserved private
+	F121 string `json:"f121,omitempty" iso:"121"` // reserved private
+	F122 string `json:"f122,omitempty" iso:"122"` // reserved private
+	F123 string `json:"f123,omitempty" iso:"123"` // reserved private
+	F124 string `json:"f124,omitempty" iso:"124"` // reserved private
+	F125 string `json:"f125,omitempty" iso:"125"` // reserved private
+	F126 string `json:"f126,omitempty" iso:"126"` // reserved private
+	F127 string `json:"f127,omitempty" iso:"127"` // reserved private
+	F128 string `json:"f128,omitempty" iso:"128"` // message authentication code
 }